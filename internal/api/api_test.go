@@ -0,0 +1,469 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/config"
+	"p2pfs/internal/dag/exporter"
+	"p2pfs/internal/node"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+func newTestServer(t *testing.T) (*node.Node, *Client) {
+	t.Helper()
+	n, err := node.New(context.Background(), node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	mux := http.NewServeMux()
+	NewServer(n).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return n, NewClient(srv.URL)
+}
+
+// newTestRepo creates and opens a repo listening on loopback with no
+// bootstrap peers and reproviding disabled, after applying edit to its
+// config.
+func newTestRepo(t *testing.T, edit func(*config.Config)) *repo.Repo {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo")
+	cfg := config.Default()
+	cfg.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.Addresses.NoAnnounce = nil
+	cfg.Bootstrap = nil
+	cfg.Reprovider.Interval = 0
+	edit(cfg)
+	if err := repo.Init(path, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestClientAddCatLs(t *testing.T) {
+	n, c := newTestServer(t)
+	ctx := context.Background()
+
+	id, err := c.Add(ctx, "hello.txt", bytes.NewReader([]byte("hello api")), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := n.Blockstore.Has(ctx, id); !has {
+		t.Fatalf("added block %s not in node blockstore", id)
+	}
+
+	data, err := c.Cat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello api" {
+		t.Fatalf("cat returned %q", data)
+	}
+
+	links, err := c.Ls(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("expected no links for a raw block, got %v", links)
+	}
+
+	info, err := c.ID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != n.Host.ID().String() {
+		t.Fatalf("id returned %s, want %s", info.ID, n.Host.ID())
+	}
+}
+
+func TestClientGetMatchesExport(t *testing.T) {
+	n, c := newTestServer(t)
+	ctx := context.Background()
+
+	id, err := c.Add(ctx, "get.txt", bytes.NewReader([]byte("same bytes either way")), AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var local bytes.Buffer
+	if err := exporter.Export(ctx, id, n.Blockstore, &local); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, local.Bytes()) || string(data) != "same bytes either way" {
+		t.Fatalf("get returned %q, local export %q", data, local.Bytes())
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	_, c := newTestServer(t)
+	missing := blockformat.NewBlock([]byte("missing")).Cid()
+	if _, err := c.Cat(context.Background(), missing); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClientAddQueuesProvide(t *testing.T) {
+	n, c := newTestServer(t)
+	ctx := context.Background()
+
+	quiet, err := c.Add(ctx, "quiet.txt", bytes.NewReader([]byte("not announced")), AddOptions{NoProvide: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := n.Pins.Has(ctx, quiet); pinned {
+		t.Fatalf("added root %s pinned", quiet)
+	}
+	if l, _ := n.ProvideQueue.Len(ctx); l != 0 {
+		t.Fatalf("provide queue has %d entries after add --no-provide", l)
+	}
+	if _, err := n.Datastore.Get(ctx, routing.NoProvideBucket, quiet.Bytes()); err != nil {
+		t.Fatalf("add --no-provide not recorded for the reprovider: %v", err)
+	}
+
+	// the node has no DHT peers, so the entry stays queued for a retry
+	if _, err := c.Add(ctx, "loud.txt", bytes.NewReader([]byte("announced")), AddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := n.ProvideQueue.Len(ctx); l != 1 {
+		t.Fatalf("provide queue has %d entries, want 1", l)
+	}
+}
+
+func TestDelegatedRouting(t *testing.T) {
+	server, c := newTestServer(t)
+	ctx := context.Background()
+	blk := blockformat.NewBlock([]byte("found through delegated routing"))
+	if err := server.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	// the provider record is stored locally even though the DHT has no
+	// peers to replicate it to
+	server.DHT.Provide(ctx, blk.Cid(), true)
+
+	router := routing.NewHTTPRouter(c.baseURL, nil)
+	providers := routing.FindProviders(ctx, router, blk.Cid(), 0)
+	if len(providers) != 1 || providers[0].ID != server.Host.ID() || len(providers[0].Addrs) == 0 {
+		t.Fatalf("providers = %v, want %s with addresses", providers, server.Host.ID())
+	}
+	resp, err := http.Get(c.baseURL + routing.ProvidersPath + blk.Cid().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("plain JSON lookup: %s, %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	missing := blockformat.NewBlock([]byte("nobody has this")).Cid()
+	if providers := routing.FindProviders(ctx, router, missing, 0); len(providers) != 0 {
+		t.Fatalf("providers of unknown CID = %v", providers)
+	}
+
+	// a thin client running no DHT fetches the block from the provider
+	r := newTestRepo(t, func(cfg *config.Config) {
+		cfg.Routing.Type = routing.TypeDelegated
+		cfg.Routing.DelegatedRouters = []string{c.baseURL}
+	})
+	client, err := node.New(ctx, node.Config{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	got, err := client.Bitswap.GetBlock(ctx, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.RawData()) != string(blk.RawData()) {
+		t.Fatalf("fetched %q", got.RawData())
+	}
+
+	// its own API answers provider lookups through the delegated router
+	mux := http.NewServeMux()
+	NewServer(client).Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	providers = routing.FindProviders(ctx, NewClient(srv.URL).Router(), blk.Cid(), 0)
+	if len(providers) != 1 || providers[0].ID != server.Host.ID() {
+		t.Fatalf("providers through the thin client = %v, want %s", providers, server.Host.ID())
+	}
+	if _, err := NewClient(srv.URL).DHTTable(ctx); err == nil {
+		t.Fatal("expected an error for the DHT table of a node without a DHT")
+	}
+}
+
+func TestRoutingOnlyMux(t *testing.T) {
+	n, _ := newTestServer(t)
+	ctx := context.Background()
+	blk := blockformat.NewBlock([]byte("routing listener"))
+	if err := n.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	n.DHT.Provide(ctx, blk.Cid(), true)
+
+	mux := http.NewServeMux()
+	NewServer(n).RegisterRouting(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	providers := routing.FindProviders(ctx, routing.NewHTTPRouter(srv.URL, nil), blk.Cid(), 0)
+	if len(providers) != 1 || providers[0].ID != n.Host.ID() {
+		t.Fatalf("providers = %v, want %s", providers, n.Host.ID())
+	}
+	// the rest of the API is not reachable there
+	if _, err := NewClient(srv.URL).Cat(ctx, blk.Cid()); err == nil {
+		t.Fatal("cat served on the routing-only mux")
+	}
+}
+
+func TestPublicMux(t *testing.T) {
+	n, _ := newTestServer(t)
+	ctx := context.Background()
+	blk := blockformat.NewBlock([]byte("public listener"))
+	if err := n.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	NewServer(n).RegisterPublic(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	data, err := NewClient(srv.URL).Cat(ctx, blk.Cid())
+	if err != nil || string(data) != "public listener" {
+		t.Fatalf("cat on the public mux: %q, %v", data, err)
+	}
+	for _, route := range []string{"/api/add", "/api/pin", "/api/connect", "/api/fetch", "/api/dht/query", "/api/name/publish", "/api/pubsub/pub"} {
+		resp, err := http.Post(srv.URL+route, "application/octet-stream", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("POST %s on the public mux: %s", route, resp.Status)
+		}
+	}
+}
+
+func TestPublicMuxProtected(t *testing.T) {
+	leaf := merkledag.NewRawNode([]byte("protected leaf"))
+	root := merkledag.NodeWithData([]byte("protected root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRepo(t, func(cfg *config.Config) {
+		cfg.Bitswap.ProtectedRoots = []config.ProtectedRoot{{Cid: root.Cid().String()}}
+	})
+	n, err := node.New(context.Background(), node.Config{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	ctx := context.Background()
+	for _, blk := range []blockformat.Block{leaf, root} {
+		if err := n.Blockstore.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	NewServer(n).RegisterPublic(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	for _, id := range []cid.Cid{root.Cid(), leaf.Cid()} {
+		if _, err := c.Cat(ctx, id); !errors.Is(err, blockstore.ErrNotFound) {
+			t.Fatalf("cat %s: expected ErrNotFound, got %v", id, err)
+		}
+		if _, err := c.Get(ctx, id); !errors.Is(err, blockstore.ErrNotFound) {
+			t.Fatalf("get %s: expected ErrNotFound, got %v", id, err)
+		}
+	}
+	if _, err := c.Ls(ctx, root.Cid()); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatalf("ls: expected ErrNotFound, got %v", err)
+	}
+	for _, p := range []string{"/ipfs/" + root.Cid().String(), "/ipfs/" + root.Cid().String() + "/leaf", "/ipfs/" + leaf.Cid().String()} {
+		resp, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("GET %s on the public mux: %s", p, resp.Status)
+		}
+	}
+
+	// the owner still reads them through the local API
+	local := http.NewServeMux()
+	NewServer(n).Register(local)
+	lsrv := httptest.NewServer(local)
+	defer lsrv.Close()
+	data, err := NewClient(lsrv.URL).Cat(ctx, leaf.Cid())
+	if err != nil || string(data) != string(leaf.RawData()) {
+		t.Fatalf("cat on the local API: %q, %v", data, err)
+	}
+}
+
+func TestDHTTable(t *testing.T) {
+	_, c := newTestServer(t)
+	ctx := context.Background()
+	table, err := c.DHTTable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without public addresses the auto mode stays a client, and client
+	// peers are never added to the routing table
+	if table.Mode != routing.ModeClient || len(table.Buckets) != 0 {
+		t.Fatalf("table = %+v, want an empty client table", table)
+	}
+
+	resp, err := http.Get(c.baseURL + "/api/dht/query?peer=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("query with invalid peer id: %s", resp.Status)
+	}
+}
+
+func TestNamePublishAndGateway(t *testing.T) {
+	n, c := newTestServer(t)
+	ctx := context.Background()
+	id, err := c.Add(ctx, "hello.txt", bytes.NewReader([]byte("hello ipns")), AddOptions{NoProvide: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node has no DHT peers: the record is only kept locally
+	res, err := c.NamePublish(ctx, id.String(), NamePublishOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PublishError == "" {
+		t.Fatal("expected a publish warning from a node without DHT peers")
+	}
+	if want := ipns.NameFromPeer(n.Host.ID()).String(); res.Name != want {
+		t.Fatalf("published name %s, want %s", res.Name, want)
+	}
+	if res.Value != "/ipfs/"+id.String() {
+		t.Fatalf("published value %s", res.Value)
+	}
+
+	p, err := c.NameResolve(ctx, res.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != res.Value {
+		t.Fatalf("resolved %s, want %s", p, res.Value)
+	}
+
+	for _, u := range []string{"/ipfs/" + id.String(), "/ipns/" + res.Name} {
+		resp, err := http.Get(c.baseURL + u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "hello ipns" {
+			t.Fatalf("GET %s: %s %q", u, resp.Status, body)
+		}
+	}
+
+	resp, err := http.Get(c.baseURL + "/ipfs/" + id.String() + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET of a missing link: %s", resp.Status)
+	}
+
+	_, unknown := newTestServer(t)
+	info, err := unknown.ID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NameResolve(ctx, info.ID); !errors.Is(err, blockstore.ErrNotFound) {
+		t.Fatalf("expected not found for an unpublished name, got %v", err)
+	}
+}
+
+func TestPubsubStream(t *testing.T) {
+	n, c := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sub, err := c.PubsubSubscribe(ctx, "datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	topics, err := c.PubsubTopics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0] != "datasets" {
+		t.Fatalf("topics = %v", topics)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		if err := c.PubsubPublish(ctx, "datasets", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		m, err := sub.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Data) != data || m.From != n.Host.ID() || m.Topic != "datasets" {
+			t.Fatalf("received %+v", m)
+		}
+		if err := m.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	peers, err := c.PubsubPeers(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("peers of an unconnected node = %v", peers)
+	}
+	if err := c.PubsubPublish(ctx, "", []byte("x")); err == nil {
+		t.Fatal("expected an error for an empty topic")
+	}
+
+	// closing the stream ends the subscription on the node
+	sub.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(n.PubSub.Topics()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("topics after close = %v", n.PubSub.Topics())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
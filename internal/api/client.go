@@ -0,0 +1,344 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/pubsub"
+	"p2pfs/internal/routing"
+)
+
+// Client talks to the HTTP API of a running node.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for the API listening on addr, given either as
+// host:port or as a full http:// URL.
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{baseURL: strings.TrimRight(addr, "/"), http: http.DefaultClient}
+}
+
+// Router returns a delegated router that looks up providers through the
+// node's Routing V1 endpoint.
+func (c *Client) Router() *routing.HTTPRouter {
+	return routing.NewHTTPRouter(c.baseURL, c.http)
+}
+
+// do sends req and returns the response if it succeeded. Error responses are
+// turned into errors; 404 wraps blockstore.ErrNotFound.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return nil, &remoteError{
+		msg:      strings.TrimSpace(string(body)),
+		notFound: resp.StatusCode == http.StatusNotFound,
+	}
+}
+
+// remoteError is an error reported by the API. A 404 response matches
+// blockstore.ErrNotFound so callers can handle it like a local miss.
+type remoteError struct {
+	msg      string
+	notFound bool
+}
+
+func (e *remoteError) Error() string { return "api: " + e.msg }
+
+func (e *remoteError) Is(target error) bool {
+	return e.notFound && target == blockstore.ErrNotFound
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) error {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ID returns the identity of the node behind the API.
+func (c *Client) ID(ctx context.Context) (p2p.Info, error) {
+	var info p2p.Info
+	err := c.getJSON(ctx, "/api/id", nil, &info)
+	return info, err
+}
+
+// AddOptions configures Client.Add.
+type AddOptions struct {
+	// NoProvide keeps the imported content unannounced, by the provide queue
+	// and the reprovider alike, until it is pinned or added again without it.
+	NoProvide bool
+}
+
+// Add imports the contents of r under the given file name. The imported
+// DAG is pinned and, unless opts.NoProvide is set, queued to be announced.
+func (c *Client) Add(ctx context.Context, name string, r io.Reader, opts AddOptions) (cid.Cid, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	u := c.baseURL + "/api/add"
+	if opts.NoProvide {
+		u += "?" + url.Values{"provide": {"false"}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, pr)
+	if err != nil {
+		pr.Close()
+		return cid.Undef, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := c.do(req)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Cid string `json:"cid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return cid.Undef, err
+	}
+	if out.Cid == "" {
+		return cid.Undef, errors.New("api: add returned no cid")
+	}
+	return cid.Parse(out.Cid)
+}
+
+// Cat returns the raw data of the block id.
+func (c *Client) Cat(ctx context.Context, id cid.Cid) ([]byte, error) {
+	resp, err := c.get(ctx, "/api/cat", url.Values{"cid": {id.String()}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Get returns the file exported from id, as `p2pfs get` writes it.
+func (c *Client) Get(ctx context.Context, id cid.Cid) ([]byte, error) {
+	resp, err := c.get(ctx, "/api/get", url.Values{"cid": {id.String()}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Ls lists the links of the DAG node id.
+func (c *Client) Ls(ctx context.Context, id cid.Cid) ([]Link, error) {
+	var links []Link
+	err := c.getJSON(ctx, "/api/ls", url.Values{"cid": {id.String()}}, &links)
+	return links, err
+}
+
+// Pin asks the node to pin id and announce that it provides it.
+func (c *Client) Pin(ctx context.Context, id cid.Cid) (*PinResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/pin?"+url.Values{"cid": {id.String()}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res PinResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DHTTable returns the DHT mode and routing table of the node.
+func (c *Client) DHTTable(ctx context.Context) (*DHTTable, error) {
+	var table DHTTable
+	if err := c.getJSON(ctx, "/api/dht/table", nil, &table); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// DHTQuery asks the node's DHT for the peers closest to id.
+func (c *Client) DHTQuery(ctx context.Context, id peer.ID) ([]peer.ID, error) {
+	var ids []string
+	if err := c.getJSON(ctx, "/api/dht/query", url.Values{"peer": {id.String()}}, &ids); err != nil {
+		return nil, err
+	}
+	peers := make([]peer.ID, 0, len(ids))
+	for _, s := range ids {
+		p, err := peer.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// NamePublishOptions configures Client.NamePublish.
+type NamePublishOptions struct {
+	// Key names the keystore key to publish with; empty means the node
+	// identity.
+	Key string
+	// Lifetime and TTL of the record; zero keeps the defaults.
+	Lifetime time.Duration
+	TTL      time.Duration
+}
+
+// NamePublish points the name of a node key at value, a path such as
+// /ipfs/<cid>.
+func (c *Client) NamePublish(ctx context.Context, value string, opts NamePublishOptions) (*PublishResult, error) {
+	q := url.Values{"value": {value}}
+	if opts.Key != "" {
+		q.Set("key", opts.Key)
+	}
+	if opts.Lifetime > 0 {
+		q.Set("lifetime", opts.Lifetime.String())
+	}
+	if opts.TTL > 0 {
+		q.Set("ttl", opts.TTL.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/name/publish?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res PublishResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// NameResolve returns the /ipfs/ path name currently points at.
+func (c *Client) NameResolve(ctx context.Context, name string) (string, error) {
+	var out struct {
+		Path string `json:"path"`
+	}
+	err := c.getJSON(ctx, "/api/name/resolve", url.Values{"name": {name}}, &out)
+	return out.Path, err
+}
+
+// PubsubPublish publishes data on topic through the node.
+func (c *Client) PubsubPublish(ctx context.Context, topic string, data []byte) error {
+	u := c.baseURL + "/api/pubsub/pub?" + url.Values{"topic": {topic}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PubsubSubscribe subscribes the node to topic until the returned
+// subscription is closed or ctx is done.
+func (c *Client) PubsubSubscribe(ctx context.Context, topic string) (*PubsubSubscription, error) {
+	resp, err := c.get(ctx, "/api/pubsub/sub", url.Values{"topic": {topic}})
+	if err != nil {
+		return nil, err
+	}
+	return &PubsubSubscription{body: resp.Body, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// PubsubSubscription reads the messages streamed by /api/pubsub/sub.
+type PubsubSubscription struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+// Next returns the next message. It returns io.EOF if the node ended the
+// subscription.
+func (s *PubsubSubscription) Next() (*pubsub.Message, error) {
+	var m pubsub.Message
+	if err := s.dec.Decode(&m); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Close ends the subscription.
+func (s *PubsubSubscription) Close() error {
+	return s.body.Close()
+}
+
+// PubsubTopics returns the topics the node subscribes to.
+func (c *Client) PubsubTopics(ctx context.Context) ([]string, error) {
+	var topics []string
+	err := c.getJSON(ctx, "/api/pubsub/ls", nil, &topics)
+	return topics, err
+}
+
+// PubsubPeers returns the peers the node exchanges messages of topic with,
+// or all its pubsub peers if topic is empty.
+func (c *Client) PubsubPeers(ctx context.Context, topic string) ([]peer.ID, error) {
+	var q url.Values
+	if topic != "" {
+		q = url.Values{"topic": {topic}}
+	}
+	var ids []string
+	if err := c.getJSON(ctx, "/api/pubsub/peers", q, &ids); err != nil {
+		return nil, err
+	}
+	peers := make([]peer.ID, 0, len(ids))
+	for _, s := range ids {
+		p, err := peer.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+	"p2pfs/internal/namesys"
+)
+
+// nameTimeout bounds the DHT operations of name requests.
+const nameTimeout = time.Minute
+
+// PublishResult is the reply of /api/name/publish. PublishError is set if
+// the record was signed and stored locally but putting it to the DHT
+// failed.
+type PublishResult struct {
+	Name         string `json:"name"`
+	Value        string `json:"value"`
+	Sequence     uint64 `json:"sequence"`
+	PublishError string `json:"publishError,omitempty"`
+}
+
+// ParseContentPath parses s as a content path, e.g. /ipfs/<cid>/file or
+// /ipns/<name>, or as a bare CID meaning /ipfs/<cid>.
+func ParseContentPath(s string) (path.Path, error) {
+	if !strings.HasPrefix(s, "/") {
+		c, err := cid.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		return path.FromCid(c), nil
+	}
+	return path.NewPath(s)
+}
+
+func (s *Server) handleNamePublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	value, err := ParseContentPath(q.Get("value"))
+	if err != nil {
+		http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var opts namesys.PublishOptions
+	if opts.Lifetime, err = parseDuration(q.Get("lifetime")); err != nil {
+		http.Error(w, "invalid lifetime", http.StatusBadRequest)
+		return
+	}
+	if opts.TTL, err = parseDuration(q.Get("ttl")); err != nil {
+		http.Error(w, "invalid ttl", http.StatusBadRequest)
+		return
+	}
+
+	sk, err := s.node.Key(q.Get("key"))
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), nameTimeout)
+	defer cancel()
+	rec, err := s.node.Names.Publish(ctx, sk, value, opts)
+	if err != nil && !errors.Is(err, namesys.ErrRoutingPut) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	seq, _ := rec.Sequence()
+	res := PublishResult{
+		Name:     ipns.NameFromPeer(pid).String(),
+		Value:    value.String(),
+		Sequence: seq,
+	}
+	if err != nil {
+		res.PublishError = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// parseDuration parses an optional duration query parameter.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (s *Server) handleNameResolve(w http.ResponseWriter, r *http.Request) {
+	name, err := ipns.NameFromString(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), nameTimeout)
+	defer cancel()
+	p, err := s.node.Names.Resolve(ctx, name.AsPath())
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"path": p.String()})
+}
+
+// handleGateway serves content by path: /ipfs/<cid>/<name>... or
+// /ipns/<name>/<name>..., following named DAG links and fetching missing
+// blocks from the network. DAG nodes with links are listed one link per
+// line; other blocks are served as raw data.
+func (s *Server) handleGateway(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := path.NewPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), nameTimeout)
+	defer cancel()
+	ip, err := s.node.Names.Resolve(ctx, p)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	getBlock := func(ctx context.Context, c cid.Cid) (blockformat.Block, error) {
+		if s.hidden(r, c) {
+			return nil, blockstore.ErrNotFound
+		}
+		return s.node.Bitswap.GetBlock(ctx, c)
+	}
+	c, err := dag.ResolvePath(ctx, getBlock, ip.RootCid(), ip.Segments()[2:])
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	blk, err := getBlock(ctx, c)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("X-Ipfs-Path", p.String())
+	if node, err := dag.DecodeNode(blk.RawData()); err == nil && len(node.Links()) > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, link := range node.Links() {
+			w.Write([]byte(link.Name + "\t" + link.Cid.String() + "\n"))
+		}
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(blk.RawData()))
+	w.Write(blk.RawData())
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"p2pfs/internal/pubsub"
+)
+
+func (s *Server) handlePubsubPub(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "topic query param required", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, pubsub.MaxMessageSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > pubsub.MaxMessageSize {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := s.node.PubSub.Publish(topic, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePubsubSub subscribes to a topic for as long as the request lasts
+// and streams the messages as newline-delimited JSON.
+func (s *Server) handlePubsubSub(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "topic query param required", http.StatusBadRequest)
+		return
+	}
+	sub, err := s.node.PubSub.Subscribe(topic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Cancel()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	// let the client know the subscription is in place
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		m, err := sub.Next(r.Context())
+		if err != nil {
+			return
+		}
+		if err := enc.Encode(m); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) handlePubsubLs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.PubSub.Topics())
+}
+
+func (s *Server) handlePubsubPeers(w http.ResponseWriter, r *http.Request) {
+	ids := []string{}
+	for _, p := range s.node.PubSub.ListPeers(r.URL.Query().Get("topic")) {
+		ids = append(ids, p.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
@@ -0,0 +1,407 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+	"p2pfs/internal/dag/exporter"
+	"p2pfs/internal/dag/importer"
+	"p2pfs/internal/namesys"
+	"p2pfs/internal/node"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+// Server serves the HTTP API of a node. It is used both by the web interface
+// (`serve`) and by CLI commands talking to a running daemon.
+type Server struct {
+	node *node.Node
+
+	mu          sync.Mutex
+	sharedFiles map[string]string
+}
+
+// NewServer returns an API server for n, loading the shared files index from
+// the node's datastore.
+func NewServer(n *node.Node) *Server {
+	s := &Server{node: n, sharedFiles: make(map[string]string)}
+	err := n.Datastore.ForEach(context.Background(), repo.SharedBucket, func(key, value []byte) error {
+		s.sharedFiles[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to load shared files index: %v", err)
+	}
+	return s
+}
+
+// Register adds the API routes to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/id", s.handleID)
+	mux.HandleFunc("/api/add", s.handleAdd)
+	mux.HandleFunc("/api/cat", s.handleCat)
+	mux.HandleFunc("/api/get", s.handleGet)
+	mux.HandleFunc("/api/ls", s.handleLs)
+	mux.HandleFunc("/api/pin", s.handlePin)
+	mux.HandleFunc("/api/connect", s.handleConnect)
+	mux.HandleFunc("/api/fetch", s.handleFetch)
+	mux.HandleFunc("/api/shared", s.handleShared)
+	mux.HandleFunc("/api/dht/table", s.handleDHTTable)
+	mux.HandleFunc("/api/dht/query", s.handleDHTQuery)
+	mux.HandleFunc("/api/name/publish", s.handleNamePublish)
+	mux.HandleFunc("/api/name/resolve", s.handleNameResolve)
+	mux.HandleFunc("/api/pubsub/pub", s.handlePubsubPub)
+	mux.HandleFunc("/api/pubsub/sub", s.handlePubsubSub)
+	mux.HandleFunc("/api/pubsub/ls", s.handlePubsubLs)
+	mux.HandleFunc("/api/pubsub/peers", s.handlePubsubPeers)
+	mux.HandleFunc("/ipfs/", s.handleGateway)
+	mux.HandleFunc("/ipns/", s.handleGateway)
+	s.RegisterRouting(mux)
+}
+
+// RegisterPublic adds the read-only routes to mux, for a listener other
+// hosts can reach: node info, cat, get, ls, the shared files listing, name
+// resolution and the gateway. Routes that change the node, connect it to
+// peers or sign with its keys are left out, and blocks of protected DAGs are
+// answered as not found.
+func (s *Server) RegisterPublic(mux *http.ServeMux) {
+	mux.HandleFunc("/api/id", s.handleID)
+	mux.HandleFunc("/api/cat", public(s.handleCat))
+	mux.HandleFunc("/api/get", public(s.handleGet))
+	mux.HandleFunc("/api/ls", public(s.handleLs))
+	mux.HandleFunc("/api/shared", s.handleShared)
+	mux.HandleFunc("/api/name/resolve", s.handleNameResolve)
+	mux.HandleFunc("/ipfs/", public(s.handleGateway))
+	mux.HandleFunc("/ipns/", public(s.handleGateway))
+}
+
+// publicKey marks the context of requests received on public routes.
+type publicKey struct{}
+
+// public marks requests to h as received on a public route.
+func public(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), publicKey{}, true)))
+	}
+}
+
+// hidden reports whether block c must be withheld from r. Protected DAGs are
+// only served to their allowed peers over Bitswap, which HTTP clients on a
+// public route cannot prove to be.
+func (s *Server) hidden(r *http.Request, c cid.Cid) bool {
+	return r.Context().Value(publicKey{}) != nil && s.node.Protected(c)
+}
+
+// RegisterRouting adds the read-only Routing V1 endpoint to mux. It is part
+// of Register, and is used alone where the endpoint is exposed to other
+// nodes.
+func (s *Server) RegisterRouting(mux *http.ServeMux) {
+	// answer from the DHT only, so daemons delegating to each other do not
+	// forward lookups in a loop. Without a DHT the endpoint answers from the
+	// static and delegated routers, and is only served on the local API.
+	var router routing.ContentRouting = s.node.Routing
+	if s.node.DHT != nil {
+		router = s.node.DHT
+	}
+	mux.Handle(routing.ProvidersPath, routing.NewHTTPHandler(router, s.node.Host))
+}
+
+// httpStatus maps an error to the HTTP status code reported for it.
+func httpStatus(err error) int {
+	if errors.Is(err, blockstore.ErrNotFound) || errors.Is(err, namesys.ErrNotFound) || errors.Is(err, dag.ErrNoLink) ||
+		errors.Is(err, repo.ErrKeyNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// recordShared adds name to the shared files index and persists it.
+func (s *Server) recordShared(name, cidStr string, onlyNew bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.sharedFiles[name]; exists && onlyNew {
+		return
+	}
+	s.sharedFiles[name] = cidStr
+	if err := s.node.Datastore.Put(context.Background(), repo.SharedBucket, []byte(name), []byte(cidStr)); err != nil {
+		log.Printf("failed to persist shared metadata: %v", err)
+	}
+}
+
+func (s *Server) handleID(w http.ResponseWriter, r *http.Request) {
+	info, err := p2p.HostInfo(s.node.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file, fh, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, file); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cidKey, err := importer.ImportFile(r.Context(), tmp.Name(), s.node.Blockstore)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	provide := s.node.QueueProvide
+	if r.URL.Query().Get("provide") == "false" {
+		provide = s.node.SkipProvide
+	}
+	if err := provide(r.Context(), cidKey); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"cid": cidKey.String()})
+	// record uploaded file metadata and persist
+	s.recordShared(fh.Filename, cidKey.String(), false)
+}
+
+func (s *Server) handleCat(w http.ResponseWriter, r *http.Request) {
+	cidStr := r.URL.Query().Get("cid")
+	cidKey, err := cid.Parse(cidStr)
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	if s.hidden(r, cidKey) {
+		http.Error(w, blockstore.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	blk, err := s.node.Blockstore.Get(r.Context(), cidKey)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(blk.RawData())
+
+	// record fetched block into shared metadata
+	s.recordShared(cidStr, cidStr, true)
+}
+
+// handleGet exports a file the way `p2pfs get` does without a daemon.
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	cidKey, err := cid.Parse(r.URL.Query().Get("cid"))
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	if s.hidden(r, cidKey) {
+		http.Error(w, blockstore.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	// Export writes nothing on failure, so the error can still be reported
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := exporter.Export(r.Context(), cidKey, s.node.Blockstore, w); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+	}
+}
+
+// Link is a named link of a DAG node as returned by /api/ls.
+type Link struct {
+	Name string `json:"name"`
+	Cid  string `json:"cid"`
+}
+
+func (s *Server) handleLs(w http.ResponseWriter, r *http.Request) {
+	cidStr := r.URL.Query().Get("cid")
+	cidKey, err := cid.Parse(cidStr)
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	if s.hidden(r, cidKey) {
+		http.Error(w, blockstore.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	blk, err := s.node.Blockstore.Get(r.Context(), cidKey)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	links := []Link{}
+	if nodeProto, err := merkledag.DecodeProtobuf(blk.RawData()); err == nil {
+		for _, link := range nodeProto.Links() {
+			links = append(links, Link{Name: link.Name, Cid: link.Cid.String()})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// PinResult is the reply of /api/pin. ProvideError is set if the pin was
+// recorded but announcing it failed; the reprovider retries it later.
+type PinResult struct {
+	Cid          string `json:"cid"`
+	ProvideError string `json:"provideError,omitempty"`
+}
+
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cidKey, err := cid.Parse(r.URL.Query().Get("cid"))
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	if err := s.node.Pins.Add(r.Context(), cidKey); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// pinning announces the DAG, whatever it was added with
+	if err := routing.SetNoProvide(r.Context(), s.node.Datastore, cidKey, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := PinResult{Cid: cidKey.String()}
+	if err := s.node.Bitswap.ProvideBlock(r.Context(), cidKey); err != nil {
+		res.ProvideError = err.Error()
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// DHTTable is the reply of /api/dht/table.
+type DHTTable struct {
+	// Mode is the current DHT mode, client or server.
+	Mode    string
+	Buckets []routing.Bucket
+}
+
+// noDHT answers a DHT request on a node that runs no DHT and reports
+// whether it did.
+func (s *Server) noDHT(w http.ResponseWriter) bool {
+	if s.node.DHT != nil {
+		return false
+	}
+	http.Error(w, "no DHT: routing type is "+routing.TypeDelegated, http.StatusNotImplemented)
+	return true
+}
+
+func (s *Server) handleDHTTable(w http.ResponseWriter, r *http.Request) {
+	if s.noDHT(w) {
+		return
+	}
+	table := DHTTable{Mode: s.node.DHT.Mode(), Buckets: s.node.DHT.RoutingTable()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(table)
+}
+
+// dhtQueryTimeout bounds a closest peers query served by /api/dht/query.
+const dhtQueryTimeout = time.Minute
+
+func (s *Server) handleDHTQuery(w http.ResponseWriter, r *http.Request) {
+	if s.noDHT(w) {
+		return
+	}
+	id, err := peer.Decode(r.URL.Query().Get("peer"))
+	if err != nil {
+		http.Error(w, "invalid peer id", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dhtQueryTimeout)
+	defer cancel()
+	peers, err := s.node.DHT.GetClosestPeers(ctx, id)
+	if err != nil {
+		http.Error(w, "dht query failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ids := []string{}
+	for _, p := range peers {
+		ids = append(ids, p.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ids)
+}
+
+// P2P connect endpoint
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	addrStr := r.URL.Query().Get("addr")
+	if addrStr == "" {
+		http.Error(w, "addr query param required", http.StatusBadRequest)
+		return
+	}
+	maddr, err := multiaddr.NewMultiaddr(addrStr)
+	if err != nil {
+		http.Error(w, "invalid multiaddr", http.StatusBadRequest)
+		return
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		http.Error(w, "invalid peer addr", http.StatusBadRequest)
+		return
+	}
+	ctxDial, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := s.node.Host.Connect(ctxDial, *info); err != nil {
+		http.Error(w, "connect failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "connected"})
+}
+
+// P2P fetch endpoint via Bitswap
+func (s *Server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	cidStr := r.URL.Query().Get("cid")
+	cidKey, err := cid.Parse(cidStr)
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	blk, err := s.node.Bitswap.GetBlock(r.Context(), cidKey)
+	if err != nil {
+		http.Error(w, "fetch error: "+err.Error(), httpStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(blk.RawData())
+
+	// record fetched block into shared metadata
+	s.recordShared(cidStr, cidStr, true)
+}
+
+// Shared files listing
+func (s *Server) handleShared(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.sharedFiles)
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	blockformat "github.com/ipfs/go-block-format"
@@ -19,21 +21,79 @@ import (
 
 const BitswapProtocol = "/p2pfs/bitswap/1.0.0"
 
+// Connection manager tags. Peers are protected while an exchange with them is
+// in flight; usefulTag grows with every block exchanged.
+const (
+	activeProtectTag = "bitswap"
+	usefulTag        = "bitswap-useful"
+	usefulTagStep    = 10
+	usefulTagMax     = 100
+)
+
 // Bitswap implements a simple block exchange protocol.
 type Bitswap struct {
-	host corehost.Host
-	dht  *routing.KademliaDHT
-	bs   blockstore.Blockstore
+	host   corehost.Host
+	router routing.ContentRouting
+	bs     blockstore.Blockstore
+
+	mu     sync.Mutex
+	peers  map[peer.ID]struct{}
+	active map[peer.ID]int // in-flight exchanges per peer
+	policy AccessPolicy
 }
 
 // NewBitswap returns a new Bitswap instance and sets the stream handler.
-func NewBitswap(host corehost.Host, dht *routing.KademliaDHT, bs blockstore.Blockstore) *Bitswap {
-	b := &Bitswap{host: host, dht: dht, bs: bs}
+// Providers are found and announced through router.
+func NewBitswap(host corehost.Host, router routing.ContentRouting, bs blockstore.Blockstore) *Bitswap {
+	b := &Bitswap{
+		host:   host,
+		router: router,
+		bs:     bs,
+		peers:  make(map[peer.ID]struct{}),
+		active: make(map[peer.ID]int),
+	}
 	host.SetStreamHandler(BitswapProtocol, b.handleStream)
+	host.Network().Notify(&cnetwork.NotifyBundle{
+		DisconnectedF: func(n cnetwork.Network, c cnetwork.Conn) {
+			// drop the peer once its last connection is gone
+			if p := c.RemotePeer(); n.Connectedness(p) != cnetwork.Connected {
+				b.RemovePeer(p)
+			}
+		},
+	})
 	return b
 }
 
+// AddPeer adds p to the peer set. Peers in the set are asked for every
+// block that is not available locally, alongside the providers found by the
+// router. A peer leaves the set when the host disconnects from it.
+func (b *Bitswap) AddPeer(p peer.ID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peers[p] = struct{}{}
+}
+
+// RemovePeer removes p from the peer set.
+func (b *Bitswap) RemovePeer(p peer.ID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.peers, p)
+}
+
+// Peers returns the peer set.
+func (b *Bitswap) Peers() []peer.ID {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peers := make([]peer.ID, 0, len(b.peers))
+	for p := range b.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
 // GetBlock retrieves a block by CID, either from local store or peers.
+// Providers are contacted as soon as the router finds them, alongside the
+// peer set; connected peers are only asked if neither turns up anyone.
 func (b *Bitswap) GetBlock(ctx context.Context, cidKey cid.Cid) (blockformat.Block, error) {
 	// Try local store
 	has, err := b.bs.Has(ctx, cidKey)
@@ -43,68 +103,229 @@ func (b *Bitswap) GetBlock(ctx context.Context, cidKey cid.Cid) (blockformat.Blo
 	if has {
 		return b.bs.Get(ctx, cidKey)
 	}
-	// Find providers
-	providers, err := b.dht.FindProviders(ctx, cidKey, 10)
-	if err != nil {
-		return nil, err
-	}
-	// fallback to directly connected peers if no providers found via DHT
-	if len(providers) == 0 {
-		for _, pid := range b.host.Peerstore().Peers() {
-			providers = append(providers, peer.AddrInfo{ID: pid, Addrs: b.host.Peerstore().Addrs(pid)})
-		}
-	}
-	// Query each provider
-	for _, pi := range providers {
-		if err := b.host.Connect(ctx, pi); err != nil {
-			continue
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// stream candidates: the peer set first, then providers as they appear
+	candidates := make(chan peer.AddrInfo)
+	go func() {
+		defer close(candidates)
+		for _, pid := range b.Peers() {
+			select {
+			case candidates <- peer.AddrInfo{ID: pid, Addrs: b.host.Peerstore().Addrs(pid)}:
+			case <-ctx.Done():
+				return
+			}
 		}
-		s, err := b.host.NewStream(ctx, pi.ID, BitswapProtocol)
-		if err != nil {
-			continue
+		for pi := range b.router.FindProvidersAsync(ctx, cidKey, maxProviders) {
+			select {
+			case candidates <- pi:
+			case <-ctx.Done():
+				return
+			}
 		}
-		// send request
-		req := struct{ Cid string }{Cid: cidKey.String()}
-		w := bufio.NewWriter(s)
-		if err := json.NewEncoder(w).Encode(&req); err != nil {
-			s.Close()
-			continue
+	}()
+	blk, tried := b.fetchFirst(ctx, candidates, cidKey)
+	// fallback to directly connected peers if no providers were found
+	if blk == nil && tried == 0 && ctx.Err() == nil {
+		fallback := make(chan peer.AddrInfo)
+		go func() {
+			defer close(fallback)
+			for _, pid := range b.host.Peerstore().Peers() {
+				if pid == b.host.ID() {
+					continue
+				}
+				select {
+				case fallback <- peer.AddrInfo{ID: pid, Addrs: b.host.Peerstore().Addrs(pid)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		blk, _ = b.fetchFirst(ctx, fallback, cidKey)
+	}
+	if blk == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		w.Flush()
-		// read response
-		r := bufio.NewReader(s)
-		var resp struct {
-			Data []byte
-			Err  string
+		return nil, fmt.Errorf("%s: %w", cidKey, ErrNotFound)
+	}
+	_ = b.bs.Put(ctx, blk)
+	return blk, nil
+}
+
+// maxProviders bounds the providers looked up for a block, and
+// maxConcurrentFetches the peers asked for it at the same time.
+const (
+	maxProviders         = 10
+	maxConcurrentFetches = 4
+)
+
+// fetchFirst asks the candidate peers for the block, up to
+// maxConcurrentFetches at a time, and returns the first valid copy along
+// with the number of distinct peers tried. Peers are asked as they arrive.
+func (b *Bitswap) fetchFirst(ctx context.Context, candidates <-chan peer.AddrInfo, cidKey cid.Cid) (blockformat.Block, int) {
+	// on return, cancel the fetches still running and wait for them
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan blockformat.Block, 1)
+	sem := make(chan struct{}, maxConcurrentFetches)
+	seen := map[peer.ID]bool{b.host.ID(): true}
+	tried := 0
+	for candidates != nil {
+		select {
+		case pi, ok := <-candidates:
+			if !ok {
+				candidates = nil
+				break
+			}
+			if seen[pi.ID] {
+				continue
+			}
+			seen[pi.ID] = true
+			tried++
+			select {
+			case sem <- struct{}{}:
+			case blk := <-found:
+				return blk, tried
+			case <-ctx.Done():
+				return nil, tried
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				blk, err := b.fetchFrom(ctx, pi, cidKey)
+				if err != nil {
+					return
+				}
+				select {
+				case found <- blk:
+				default:
+				}
+			}()
+		case blk := <-found:
+			return blk, tried
+		case <-ctx.Done():
+			return nil, tried
 		}
-		if err := json.NewDecoder(r).Decode(&resp); err != nil {
-			s.Close()
-			continue
+	}
+	// the stream ended; wait for the fetches still running
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case blk := <-found:
+		return blk, tried
+	case <-done:
+		select {
+		case blk := <-found:
+			return blk, tried
+		default:
+			return nil, tried
 		}
-		s.Close()
-		if resp.Err != "" {
-			continue
+	}
+}
+
+// fetchFrom requests the block cidKey from a single peer.
+func (b *Bitswap) fetchFrom(ctx context.Context, pi peer.AddrInfo, cidKey cid.Cid) (blockformat.Block, error) {
+	if err := b.host.Connect(ctx, pi); err != nil {
+		return nil, err
+	}
+	defer b.beginExchange(pi.ID)()
+	s, err := b.host.NewStream(ctx, pi.ID, BitswapProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	// send request
+	req := struct{ Cid string }{Cid: cidKey.String()}
+	w := bufio.NewWriter(s)
+	if err := json.NewEncoder(w).Encode(&req); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	// read response
+	var resp response
+	if err := json.NewDecoder(bufio.NewReader(s)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.NotFound {
+		return nil, ErrNotFound
+	}
+	if resp.Err != "" {
+		return nil, errors.New(resp.Err)
+	}
+	blk, err := blockformat.NewBlockWithCid(resp.Data, cidKey)
+	if err != nil {
+		return nil, err
+	}
+	// never trust a peer: drop data that does not hash to the CID
+	if err := blockstore.VerifyBlock(blk); err != nil {
+		return nil, err
+	}
+	b.markUseful(pi.ID)
+	return blk, nil
+}
+
+// beginExchange protects the connection to p from the connection manager
+// until the returned function is called.
+func (b *Bitswap) beginExchange(p peer.ID) (end func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.active[p]++
+	if b.active[p] == 1 {
+		b.host.ConnManager().Protect(p, activeProtectTag)
+	}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.active[p]--
+		if b.active[p] == 0 {
+			delete(b.active, p)
+			b.host.ConnManager().Unprotect(p, activeProtectTag)
 		}
-		blk := blockformat.NewBlock(resp.Data)
-		_ = b.bs.Put(ctx, blk)
-		return blk, nil
 	}
-	return nil, ErrNotFound
 }
 
-// ProvideBlock announces that we can provide this block.
+// markUseful raises the connection manager tag of a peer that exchanged a
+// block with us, so its connection is among the last to be trimmed.
+func (b *Bitswap) markUseful(p peer.ID) {
+	b.host.ConnManager().UpsertTag(p, usefulTag, func(v int) int {
+		return min(v+usefulTagStep, usefulTagMax)
+	})
+}
+
+// ProvideBlock announces that we can provide this block. It fails if the
+// router cannot store the provider record, e.g. a DHT without peers.
 func (b *Bitswap) ProvideBlock(ctx context.Context, cidKey cid.Cid) error {
-	// attempt to announce block via DHT; ignore errors if no peers
-	_ = b.dht.Provide(ctx, cidKey, true)
-	return nil
+	return b.router.Provide(ctx, cidKey, true)
 }
 
-// ErrNotFound is returned when a block cannot be retrieved.
-var ErrNotFound = errors.New("block not found")
+// ErrNotFound is returned when a block cannot be retrieved from any peer.
+// It wraps blockstore.ErrNotFound so callers can test for either.
+var ErrNotFound = fmt.Errorf("bitswap: %w", blockstore.ErrNotFound)
+
+// response is the reply sent for a single block request. NotFound is set
+// when the serving peer does not have the block, as opposed to Err which
+// reports a failure while looking it up.
+type response struct {
+	Data     []byte
+	NotFound bool
+	Err      string
+}
 
 // handleStream services incoming Bitswap requests.
 func (b *Bitswap) handleStream(s cnetwork.Stream) {
 	defer s.Close()
+	remote := s.Conn().RemotePeer()
+	defer b.beginExchange(remote)()
 	r := bufio.NewReader(s)
 	var req struct{ Cid string }
 	if err := json.NewDecoder(r).Decode(&req); err != nil {
@@ -114,27 +335,27 @@ func (b *Bitswap) handleStream(s cnetwork.Stream) {
 	if err != nil {
 		return
 	}
-	data, err := func() ([]byte, error) {
-		has, err := b.bs.Has(context.Background(), id)
-		if err != nil || !has {
-			return nil, err
-		}
-		blk, err := b.bs.Get(context.Background(), id)
-		if err != nil {
-			return nil, err
-		}
-		return blk.RawData(), nil
-	}()
-	var resp struct {
-		Data []byte
-		Err  string
+	var resp response
+	if policy := b.accessPolicy(); policy != nil && !policy.Allow(context.Background(), remote, id) {
+		resp.NotFound = true
+		b.writeResponse(s, &resp)
+		return
 	}
-	if err != nil {
+	blk, err := b.bs.Get(context.Background(), id)
+	switch {
+	case errors.Is(err, blockstore.ErrNotFound):
+		resp.NotFound = true
+	case err != nil:
 		resp.Err = err.Error()
-	} else {
-		resp.Data = data
+	default:
+		resp.Data = blk.RawData()
+		b.markUseful(remote)
 	}
+	b.writeResponse(s, &resp)
+}
+
+func (b *Bitswap) writeResponse(s cnetwork.Stream, resp *response) {
 	w := bufio.NewWriter(s)
-	json.NewEncoder(w).Encode(&resp)
+	json.NewEncoder(w).Encode(resp)
 	w.Flush()
 }
@@ -0,0 +1,149 @@
+package bitswap
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+)
+
+// AccessPolicy decides which peers a block may be served to.
+type AccessPolicy interface {
+	Allow(ctx context.Context, p peer.ID, c cid.Cid) bool
+}
+
+// SetAccessPolicy makes the engine serve blocks only to peers the policy
+// allows. Denied requests are answered as if the block were missing. A nil
+// policy serves every peer.
+func (b *Bitswap) SetAccessPolicy(policy AccessPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.policy = policy
+}
+
+func (b *Bitswap) accessPolicy() AccessPolicy {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.policy
+}
+
+// RootPolicy restricts every block of a protected DAG to the peers allowed
+// for its root. Blocks outside protected DAGs are served to everyone; a block
+// shared by several protected DAGs is served to the peers of any of them.
+// Blocks stored through the blockstore returned by Blockstore join the
+// protected DAGs they are reachable from as they arrive.
+type RootPolicy struct {
+	bs blockstore.Blockstore
+
+	mu sync.RWMutex
+	// allowed maps each protected root to its authorized peers.
+	allowed map[cid.Cid]map[peer.ID]bool
+	// roots maps the multihash of each protected block to the roots it is
+	// reachable from, so CIDv0 and CIDv1 requests for it match alike. Links
+	// to blocks not stored yet are included.
+	roots map[string][]cid.Cid
+}
+
+// NewRootPolicy returns a policy with no protected DAGs that reads DAGs from bs.
+func NewRootPolicy(bs blockstore.Blockstore) *RootPolicy {
+	return &RootPolicy{
+		bs:      bs,
+		allowed: make(map[cid.Cid]map[peer.ID]bool),
+		roots:   make(map[string][]cid.Cid),
+	}
+}
+
+// Protect restricts root and every block reachable from it to the given
+// peers. The root need not be stored yet.
+func (p *RootPolicy) Protect(ctx context.Context, root cid.Cid, peers []peer.ID) error {
+	allowed := make(map[peer.ID]bool, len(peers))
+	for _, id := range peers {
+		allowed[id] = true
+	}
+	p.mu.Lock()
+	p.allowed[root] = allowed
+	p.mu.Unlock()
+	return p.extend(ctx, root, []cid.Cid{root})
+}
+
+// extend adds start and the blocks reachable from it to the DAGs of roots.
+func (p *RootPolicy) extend(ctx context.Context, start cid.Cid, roots []cid.Cid) error {
+	var blocks []string
+	err := dag.Walk(ctx, p.bs, start, func(id cid.Cid) error {
+		blocks = append(blocks, string(id.Hash()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range blocks {
+		for _, root := range roots {
+			if !slices.Contains(p.roots[h], root) {
+				p.roots[h] = append(p.roots[h], root)
+			}
+		}
+	}
+	return nil
+}
+
+// stored extends the protected DAGs that reach id to the blocks now
+// reachable through it.
+func (p *RootPolicy) stored(ctx context.Context, id cid.Cid) error {
+	p.mu.RLock()
+	roots := slices.Clone(p.roots[string(id.Hash())])
+	p.mu.RUnlock()
+	if len(roots) == 0 {
+		return nil
+	}
+	return p.extend(ctx, id, roots)
+}
+
+// Blockstore returns bs, which must hold the blocks the policy reads, with
+// Put extending the protected DAGs to each new block's descendants.
+func (p *RootPolicy) Blockstore(bs blockstore.Blockstore) blockstore.Blockstore {
+	return &policyBlockstore{Blockstore: bs, policy: p}
+}
+
+type policyBlockstore struct {
+	blockstore.Blockstore
+	policy *RootPolicy
+}
+
+func (b *policyBlockstore) Put(ctx context.Context, block blockformat.Block) error {
+	if err := b.Blockstore.Put(ctx, block); err != nil {
+		return err
+	}
+	return b.policy.stored(ctx, block.Cid())
+}
+
+// Protected reports whether c is a block of a protected DAG.
+func (p *RootPolicy) Protected(c cid.Cid) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, protected := p.roots[string(c.Hash())]
+	return protected
+}
+
+// Allow implements AccessPolicy.
+func (p *RootPolicy) Allow(_ context.Context, id peer.ID, c cid.Cid) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	roots, protected := p.roots[string(c.Hash())]
+	if !protected {
+		return true
+	}
+	for _, r := range roots {
+		if p.allowed[r][id] {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package blockstore
 
 import (
     "context"
+    "errors"
+    "fmt"
 
     blockformat "github.com/ipfs/go-block-format"
     "github.com/ipfs/go-cid"
@@ -13,7 +15,8 @@ const bucketName = "blocks"
 
 // BboltBlockstore persists blocks in a bbolt-backed Datastore.
 type BboltBlockstore struct {
-    ds datastore.Datastore
+    ds         datastore.Datastore
+    hashOnRead bool
 }
 
 // NewBboltBlockstore wraps a Datastore in a Blockstore.
@@ -21,6 +24,12 @@ func NewBboltBlockstore(ds datastore.Datastore) *BboltBlockstore {
     return &BboltBlockstore{ds: ds}
 }
 
+// HashOnRead enables re-hashing every block on Get so corrupt data is
+// reported as ErrHashMismatch instead of being returned.
+func (b *BboltBlockstore) HashOnRead(enabled bool) {
+    b.hashOnRead = enabled
+}
+
 func (b *BboltBlockstore) Put(ctx context.Context, block blockformat.Block) error {
     key := block.Cid().Bytes()
     data := block.RawData()
@@ -29,21 +38,36 @@ func (b *BboltBlockstore) Put(ctx context.Context, block blockformat.Block) erro
 
 func (b *BboltBlockstore) Get(ctx context.Context, id cid.Cid) (blockformat.Block, error) {
     data, err := b.ds.Get(ctx, bucketName, id.Bytes())
+    if err != nil {
+        if errors.Is(err, datastore.ErrNotFound) {
+            return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+        }
+        return nil, err
+    }
+    blk, err := blockformat.NewBlockWithCid(data, id)
     if err != nil {
         return nil, err
     }
-    blk := blockformat.NewBlock(data)
+    if b.hashOnRead {
+        if err := VerifyBlock(blk); err != nil {
+            return nil, err
+        }
+    }
     return blk, nil
 }
 
 func (b *BboltBlockstore) Delete(ctx context.Context, id cid.Cid) error {
-    return b.ds.Delete(ctx, bucketName, id.Bytes())
+    err := b.ds.Delete(ctx, bucketName, id.Bytes())
+    if errors.Is(err, datastore.ErrNotFound) {
+        return fmt.Errorf("%s: %w", id, ErrNotFound)
+    }
+    return err
 }
 
 func (b *BboltBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
     data, err := b.ds.Get(ctx, bucketName, id.Bytes())
     if err != nil {
-        if err.Error() == "key not found" || err.Error() == "bucket not found" {
+        if errors.Is(err, datastore.ErrNotFound) {
             return false, nil
         }
         return false, err
@@ -51,6 +75,22 @@ func (b *BboltBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
     return len(data) > 0, nil
 }
 
+func (b *BboltBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+    var keys []cid.Cid
+    err := b.ds.ForEach(ctx, bucketName, func(k, _ []byte) error {
+        id, err := cid.Cast(k)
+        if err != nil {
+            return fmt.Errorf("invalid block key %x: %w", k, err)
+        }
+        keys = append(keys, id)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return keysChan(ctx, keys), nil
+}
+
 func (b *BboltBlockstore) Close() error {
     return b.ds.Close()
 }
@@ -2,10 +2,13 @@ package blockstore
 
 import (
 	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 
 	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	bolt "go.etcd.io/bbolt"
 	"p2pfs/internal/datastore"
 )
@@ -83,4 +86,50 @@ func TestBboltBlockstore_PutGetDeleteHas(t *testing.T) {
 	if has {
 		t.Fatal("expected block to be deleted")
 	}
+
+	// get on a missing block reports ErrNotFound
+	if _, err := bs.Get(ctx, cid); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBboltBlockstore_HashOnRead(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := datastore.NewBboltDatastore(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := NewBboltBlockstore(ds)
+	defer bs.Close()
+	ctx := context.Background()
+
+	// a raw-codec CID must come back unchanged
+	raw := blockformat.NewBlock([]byte("raw block"))
+	rawCid := cid.NewCidV1(cid.Raw, raw.Cid().Hash())
+	rawBlk, err := blockformat.NewBlockWithCid(raw.RawData(), rawCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, rawBlk); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bs.Get(ctx, rawCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cid().Equals(rawCid) {
+		t.Fatalf("got CID %s, want %s", got.Cid(), rawCid)
+	}
+
+	// corrupt the stored bytes behind the blockstore's back
+	if err := ds.Put(ctx, bucketName, rawCid.Bytes(), []byte("bit rot")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(ctx, rawCid); err != nil {
+		t.Fatalf("unverified read should succeed, got %v", err)
+	}
+	bs.HashOnRead(true)
+	if _, err := bs.Get(ctx, rawCid); !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
 }
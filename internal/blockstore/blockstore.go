@@ -1,17 +1,61 @@
 package blockstore
 
 import (
+    "bytes"
     "context"
+    "errors"
+    "fmt"
 
     blockformat "github.com/ipfs/go-block-format"
     "github.com/ipfs/go-cid"
 )
 
+// ErrNotFound is returned (possibly wrapped) when a requested block is not stored.
+var ErrNotFound = errors.New("blockstore: block not found")
+
+// ErrHashMismatch is returned (possibly wrapped) when a block's data does not
+// hash to its CID.
+var ErrHashMismatch = errors.New("blockstore: block hash mismatch")
+
+// VerifyBlock re-hashes the block data and checks it against the block's CID.
+func VerifyBlock(block blockformat.Block) error {
+    id := block.Cid()
+    sum, err := id.Prefix().Sum(block.RawData())
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(sum.Hash(), id.Hash()) {
+        return fmt.Errorf("%s: %w", id, ErrHashMismatch)
+    }
+    return nil
+}
+
+// keysChan streams keys on a new channel until done or ctx is cancelled.
+func keysChan(ctx context.Context, keys []cid.Cid) <-chan cid.Cid {
+    out := make(chan cid.Cid)
+    go func() {
+        defer close(out)
+        for _, k := range keys {
+            select {
+            case out <- k:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return out
+}
+
 // Blockstore defines storing and retrieving IPLD blocks.
 type Blockstore interface {
     Put(ctx context.Context, block blockformat.Block) error
     Get(ctx context.Context, id cid.Cid) (blockformat.Block, error)
     Delete(ctx context.Context, id cid.Cid) error
     Has(ctx context.Context, id cid.Cid) (bool, error)
+    // AllKeysChan returns the CIDs of all stored blocks. The key set is
+    // snapshotted before the channel is returned, so callers may modify the
+    // store while draining it. The channel is closed when done or when ctx
+    // is cancelled.
+    AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
     Close() error
 }
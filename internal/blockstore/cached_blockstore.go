@@ -0,0 +1,257 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/bbloom"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheOptions configures a CachedBlockstore.
+type CacheOptions struct {
+	// Policy selects the block cache eviction policy: "arc" or "lru".
+	Policy string
+	// Size is the maximum number of blocks held in the cache. Zero disables
+	// the block cache.
+	Size int
+	// MaxBlockSize is the largest block, in bytes, that is kept in the cache.
+	// Larger blocks are always read from the inner store.
+	MaxBlockSize int
+	// BloomSize is the size of the bloom filter in bytes. Zero disables it.
+	BloomSize int
+	// BloomHashes is the number of hash functions used by the bloom filter.
+	BloomHashes int
+	// Registerer, if set, receives the cache hit/miss counters.
+	Registerer prometheus.Registerer
+}
+
+// DefaultCacheOptions returns the options used when none are given.
+func DefaultCacheOptions() CacheOptions {
+	return CacheOptions{
+		Policy:       "arc",
+		Size:         256,
+		MaxBlockSize: 1 << 20,
+		BloomSize:    512 << 10,
+		BloomHashes:  7,
+	}
+}
+
+// CacheStats reports how reads were answered by a CachedBlockstore.
+type CacheStats struct {
+	Hits           uint64 // reads served from the block cache
+	Misses         uint64 // reads that went to the inner store
+	BloomNegatives uint64 // lookups answered "absent" by the bloom filter
+}
+
+// blockCache is the subset of the hashicorp caches used here.
+type blockCache interface {
+	Get(key interface{}) (interface{}, bool)
+	Add(key, value interface{})
+	Remove(key interface{})
+}
+
+// lruCache adapts lru.Cache, whose mutators report evictions, to blockCache.
+type lruCache struct{ *lru.Cache }
+
+func (l lruCache) Add(key, value interface{}) { l.Cache.Add(key, value) }
+func (l lruCache) Remove(key interface{})     { l.Cache.Remove(key) }
+
+// CachedBlockstore is a read-through cache in front of another Blockstore.
+// Recently used blocks are kept in a bounded cache and a bloom filter answers
+// most lookups for absent blocks without touching the inner store.
+type CachedBlockstore struct {
+	inner        Blockstore
+	cache        blockCache
+	maxBlockSize int
+
+	bloom       *bbloom.Bloom
+	bloomActive atomic.Bool
+	cancelBuild context.CancelFunc
+	buildDone   chan struct{} // closed once buildBloom returns
+
+	hits           atomic.Uint64
+	misses         atomic.Uint64
+	bloomNegatives atomic.Uint64
+}
+
+// NewCachedBlockstore wraps inner with a block cache and bloom filter. The
+// bloom filter is populated in the background from inner's keys and is only
+// consulted once that has finished.
+func NewCachedBlockstore(inner Blockstore, opts CacheOptions) (*CachedBlockstore, error) {
+	c := &CachedBlockstore{inner: inner, maxBlockSize: opts.MaxBlockSize}
+	if opts.Size > 0 {
+		var err error
+		switch opts.Policy {
+		case "arc", "":
+			c.cache, err = lru.NewARC(opts.Size)
+		case "lru":
+			var l *lru.Cache
+			l, err = lru.New(opts.Size)
+			c.cache = lruCache{l}
+		default:
+			return nil, fmt.Errorf("unknown cache policy %q (want arc or lru)", opts.Policy)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Registerer != nil {
+		if err := c.register(opts.Registerer); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BloomSize > 0 {
+		bloom, err := bbloom.New(float64(opts.BloomSize*8), float64(opts.BloomHashes))
+		if err != nil {
+			return nil, err
+		}
+		c.bloom = bloom
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancelBuild = cancel
+		c.buildDone = make(chan struct{})
+		go c.buildBloom(ctx)
+	}
+	return c, nil
+}
+
+// buildBloom adds every key of the inner store to the bloom filter.
+func (c *CachedBlockstore) buildBloom(ctx context.Context) {
+	defer close(c.buildDone)
+	keys, err := c.inner.AllKeysChan(ctx)
+	if err != nil {
+		return
+	}
+	for k := range keys {
+		c.bloom.AddTS(k.Hash())
+	}
+	if ctx.Err() == nil {
+		c.bloomActive.Store(true)
+	}
+}
+
+// register exposes the cache counters to a Prometheus registerer.
+func (c *CachedBlockstore) register(reg prometheus.Registerer) error {
+	counters := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "p2pfs_blockstore_cache_hits_total",
+			Help: "Block reads served from the blockstore cache.",
+		}, func() float64 { return float64(c.hits.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "p2pfs_blockstore_cache_misses_total",
+			Help: "Block reads that missed the blockstore cache.",
+		}, func() float64 { return float64(c.misses.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "p2pfs_blockstore_bloom_negatives_total",
+			Help: "Block lookups answered as absent by the bloom filter.",
+		}, func() float64 { return float64(c.bloomNegatives.Load()) }),
+	}
+	for _, col := range counters {
+		if err := reg.Register(col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *CachedBlockstore) Stats() CacheStats {
+	return CacheStats{
+		Hits:           c.hits.Load(),
+		Misses:         c.misses.Load(),
+		BloomNegatives: c.bloomNegatives.Load(),
+	}
+}
+
+// definitelyAbsent reports whether the bloom filter rules id out.
+func (c *CachedBlockstore) definitelyAbsent(id cid.Cid) bool {
+	if c.bloom == nil || !c.bloomActive.Load() {
+		return false
+	}
+	if c.bloom.HasTS(id.Hash()) {
+		return false
+	}
+	c.bloomNegatives.Add(1)
+	return true
+}
+
+func (c *CachedBlockstore) cacheBlock(block blockformat.Block) {
+	if c.cache != nil && len(block.RawData()) <= c.maxBlockSize {
+		c.cache.Add(block.Cid(), block)
+	}
+}
+
+func (c *CachedBlockstore) Put(ctx context.Context, block blockformat.Block) error {
+	if err := c.inner.Put(ctx, block); err != nil {
+		return err
+	}
+	if c.bloom != nil {
+		c.bloom.AddTS(block.Cid().Hash())
+	}
+	c.cacheBlock(block)
+	return nil
+}
+
+func (c *CachedBlockstore) Get(ctx context.Context, id cid.Cid) (blockformat.Block, error) {
+	if c.cache != nil {
+		if v, ok := c.cache.Get(id); ok {
+			c.hits.Add(1)
+			return v.(blockformat.Block), nil
+		}
+	}
+	if c.definitelyAbsent(id) {
+		return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+	}
+	c.misses.Add(1)
+	blk, err := c.inner.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.cacheBlock(blk)
+	return blk, nil
+}
+
+func (c *CachedBlockstore) Delete(ctx context.Context, id cid.Cid) error {
+	if c.cache != nil {
+		c.cache.Remove(id)
+	}
+	return c.inner.Delete(ctx, id)
+}
+
+func (c *CachedBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
+	if c.cache != nil {
+		if _, ok := c.cache.Get(id); ok {
+			c.hits.Add(1)
+			return true, nil
+		}
+	}
+	if c.definitelyAbsent(id) {
+		return false, nil
+	}
+	c.misses.Add(1)
+	return c.inner.Has(ctx, id)
+}
+
+func (c *CachedBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return c.inner.AllKeysChan(ctx)
+}
+
+// Stop ends the background bloom filter build and waits for it to return,
+// leaving the inner store open for its owner. Lookups keep working, without
+// the bloom filter if it was not complete.
+func (c *CachedBlockstore) Stop() {
+	if c.cancelBuild != nil {
+		c.cancelBuild()
+		<-c.buildDone
+	}
+}
+
+// Close stops the bloom filter build and closes the inner store.
+func (c *CachedBlockstore) Close() error {
+	c.Stop()
+	return c.inner.Close()
+}
@@ -0,0 +1,112 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	blockformat "github.com/ipfs/go-block-format"
+)
+
+func TestCachedBlockstore_HitsAndBloom(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMapBlockstore()
+	stored := blockformat.NewBlock([]byte("stored before wrapping"))
+	if err := inner.Put(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := NewCachedBlockstore(inner, DefaultCacheOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+
+	// wait for the bloom filter to be built from the existing keys
+	deadline := time.Now().Add(5 * time.Second)
+	for !bs.bloomActive.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("bloom filter was not built")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// first read misses, second is served from the cache
+	for i := 0; i < 2; i++ {
+		got, err := bs.Get(ctx, stored.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.RawData()) != "stored before wrapping" {
+			t.Fatalf("unexpected data %q", got.RawData())
+		}
+	}
+	if st := bs.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Fatalf("unexpected stats after reads: %+v", st)
+	}
+
+	// an absent block is rejected by the bloom filter
+	absent := blockformat.NewBlock([]byte("never stored"))
+	has, err := bs.Has(ctx, absent.Cid())
+	if err != nil || has {
+		t.Fatalf("expected absent block, has=%v err=%v", has, err)
+	}
+	if _, err := bs.Get(ctx, absent.Cid()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if st := bs.Stats(); st.BloomNegatives != 2 {
+		t.Fatalf("expected 2 bloom negatives, got %+v", st)
+	}
+
+	// blocks written through the wrapper are added to the bloom filter
+	if err := bs.Put(ctx, absent); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := bs.Has(ctx, absent.Cid()); err != nil || !has {
+		t.Fatalf("expected block after put, has=%v err=%v", has, err)
+	}
+
+	// deleted blocks are evicted from the cache
+	if err := bs.Delete(ctx, stored.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(ctx, stored.Cid()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestCachedBlockstore_UnknownPolicy(t *testing.T) {
+	opts := DefaultCacheOptions()
+	opts.Policy = "fifo"
+	if _, err := NewCachedBlockstore(NewMapBlockstore(), opts); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestCachedBlockstore_StopLeavesInnerOpen(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMapBlockstore()
+	defer inner.Close()
+	blk := blockformat.NewBlock([]byte("still readable"))
+	if err := inner.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := NewCachedBlockstore(inner, DefaultCacheOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.Stop()
+	select {
+	case <-bs.buildDone:
+	default:
+		t.Fatal("bloom filter build still running after Stop")
+	}
+	bs.Stop()
+	if _, err := inner.Get(ctx, blk.Cid()); err != nil {
+		t.Fatalf("inner store after Stop: %v", err)
+	}
+	if _, err := bs.Get(ctx, blk.Cid()); err != nil {
+		t.Fatalf("cached store after Stop: %v", err)
+	}
+}
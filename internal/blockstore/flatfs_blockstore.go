@@ -0,0 +1,290 @@
+package blockstore
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// flatfsShardFunc identifies the sharding scheme used by FlatfsBlockstore. It
+// is recorded in the store root so a directory written with a different
+// layout is never misread.
+const flatfsShardFunc = "/repo/flatfs/shard/v1/next-to-last/2"
+
+const (
+	flatfsShardFile = "SHARDING"
+	flatfsExt       = ".data"
+	flatfsCidExt    = ".cid"
+)
+
+var flatfsEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// FlatfsBlockstore stores each block as a file under directories sharded by
+// the next-to-last two characters of the base32-encoded multihash. Blocks are
+// keyed by multihash only, so the same data is shared between CID versions.
+// Next to each block an empty file named after the multihash and the CID
+// version and codec records every CID the block was stored under.
+type FlatfsBlockstore struct {
+	root       string
+	syncWrites bool
+	hashOnRead bool
+}
+
+// NewFlatfsBlockstore opens or creates a flat-file blockstore rooted at dir.
+// When syncWrites is set every block file is fsynced before it is renamed
+// into place.
+func NewFlatfsBlockstore(dir string, syncWrites bool) (*FlatfsBlockstore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	shardPath := filepath.Join(dir, flatfsShardFile)
+	existing, err := os.ReadFile(shardPath)
+	switch {
+	case err == nil:
+		if got := strings.TrimSpace(string(existing)); got != flatfsShardFunc {
+			return nil, fmt.Errorf("flatfs: %s uses sharding %q, want %q", dir, got, flatfsShardFunc)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.WriteFile(shardPath, []byte(flatfsShardFunc+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	return &FlatfsBlockstore{root: dir, syncWrites: syncWrites}, nil
+}
+
+// HashOnRead enables re-hashing every block on Get so corrupt data is
+// reported as ErrHashMismatch instead of being returned.
+func (f *FlatfsBlockstore) HashOnRead(enabled bool) {
+	f.hashOnRead = enabled
+}
+
+// path returns the shard directory and file path for id.
+func (f *FlatfsBlockstore) path(id cid.Cid) (string, string) {
+	key := flatfsEncoding.EncodeToString(id.Hash())
+	shard := key
+	if len(shard) < 3 {
+		shard = strings.Repeat("_", 3-len(shard)) + shard
+	}
+	dir := filepath.Join(f.root, shard[len(shard)-3:len(shard)-1])
+	return dir, filepath.Join(dir, key+flatfsExt)
+}
+
+// cidPath returns the path of the file recording that the block of id was
+// stored under id: <multihash>.v0.cid for CIDv0, otherwise
+// <multihash>.<codec in hex>.cid.
+func (f *FlatfsBlockstore) cidPath(id cid.Cid) string {
+	dir, path := f.path(id)
+	key := strings.TrimSuffix(filepath.Base(path), flatfsExt)
+	prefix := "v0"
+	if id.Version() != 0 {
+		prefix = strconv.FormatUint(id.Type(), 16)
+	}
+	return filepath.Join(dir, key+"."+prefix+flatfsCidExt)
+}
+
+// parseCidName returns the CID recorded by a file named by cidPath.
+func parseCidName(name string) (cid.Cid, bool) {
+	key, prefix, ok := strings.Cut(strings.TrimSuffix(name, flatfsCidExt), ".")
+	if !ok {
+		return cid.Undef, false
+	}
+	hash, err := flatfsEncoding.DecodeString(key)
+	if err != nil {
+		return cid.Undef, false
+	}
+	if _, err := mh.Cast(hash); err != nil {
+		return cid.Undef, false
+	}
+	if prefix == "v0" {
+		return cid.NewCidV0(hash), true
+	}
+	codec, err := strconv.ParseUint(prefix, 16, 64)
+	if err != nil {
+		return cid.Undef, false
+	}
+	return cid.NewCidV1(codec, hash), true
+}
+
+// recordCid creates the file recording that a block in dir was stored
+// under id, if missing.
+func (f *FlatfsBlockstore) recordCid(dir string, id cid.Cid) error {
+	path := f.cidPath(id)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if f.syncWrites {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func (f *FlatfsBlockstore) Put(ctx context.Context, block blockformat.Block) error {
+	dir, path := f.path(block.Cid())
+	if _, err := os.Stat(path); err == nil {
+		// content addressed: an existing file already holds these bytes
+		return f.recordCid(dir, block.Cid())
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".temp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(block.RawData()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if f.syncWrites {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return f.recordCid(dir, block.Cid())
+}
+
+func (f *FlatfsBlockstore) Get(ctx context.Context, id cid.Cid) (blockformat.Block, error) {
+	_, path := f.path(id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+	blk, err := blockformat.NewBlockWithCid(data, id)
+	if err != nil {
+		return nil, err
+	}
+	if f.hashOnRead {
+		if err := VerifyBlock(blk); err != nil {
+			return nil, err
+		}
+	}
+	return blk, nil
+}
+
+// Delete removes the block data along with the CIDs recorded for it, since
+// the data is shared by all of them.
+func (f *FlatfsBlockstore) Delete(ctx context.Context, id cid.Cid) error {
+	_, path := f.path(id)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	recorded, err := filepath.Glob(strings.TrimSuffix(path, flatfsExt) + ".*" + flatfsCidExt)
+	if err != nil {
+		return err
+	}
+	for _, p := range recorded {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FlatfsBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
+	_, path := f.path(id)
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+// AllKeysChan lists stored blocks under the CIDs they were stored with.
+// Blocks written before CIDs were recorded are listed as CIDv1 with the raw
+// codec.
+func (f *FlatfsBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	var recorded []cid.Cid
+	stored := make(map[string]bool) // multihash -> has a recorded CID
+	err := filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || strings.HasPrefix(name, ".") {
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(name, flatfsCidExt):
+			if id, ok := parseCidName(name); ok {
+				recorded = append(recorded, id)
+			}
+		case strings.HasSuffix(name, flatfsExt):
+			hash, err := flatfsEncoding.DecodeString(strings.TrimSuffix(name, flatfsExt))
+			if err != nil {
+				return nil
+			}
+			if _, err := mh.Cast(hash); err != nil {
+				return nil
+			}
+			stored[string(hash)] = false
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var keys []cid.Cid
+	for _, id := range recorded {
+		// skip records left behind by an interrupted Delete
+		if _, ok := stored[string(id.Hash())]; ok {
+			keys = append(keys, id)
+			stored[string(id.Hash())] = true
+		}
+	}
+	for hash, hasRecord := range stored {
+		if !hasRecord {
+			keys = append(keys, cid.NewCidV1(cid.Raw, []byte(hash)))
+		}
+	}
+	return keysChan(ctx, keys), nil
+}
+
+func (f *FlatfsBlockstore) Close() error {
+	return nil
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,136 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+func TestFlatfsBlockstore_PutGetDeleteHas(t *testing.T) {
+	dir := t.TempDir()
+	bs, err := NewFlatfsBlockstore(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	ctx := context.Background()
+
+	blk := blockformat.NewBlock([]byte("hello flatfs"))
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block lives in its next-to-last/2 shard directory
+	_, path := bs.path(blk.Cid())
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected block file at %s: %v", path, err)
+	}
+	if got := filepath.Base(filepath.Dir(path)); len(got) != 2 {
+		t.Fatalf("unexpected shard directory %q", got)
+	}
+
+	has, err := bs.Has(ctx, blk.Cid())
+	if err != nil || !has {
+		t.Fatalf("expected block to be present, has=%v err=%v", has, err)
+	}
+
+	// the same multihash under a raw CIDv1 reads back the same data
+	raw := cid.NewCidV1(cid.Raw, blk.Cid().Hash())
+	got, err := bs.Get(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cid().Equals(raw) || string(got.RawData()) != "hello flatfs" {
+		t.Fatalf("unexpected block %s %q", got.Cid(), got.RawData())
+	}
+
+	if err := bs.Delete(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(ctx, blk.Cid()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFlatfsBlockstore_RejectsOtherSharding(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, flatfsShardFile), []byte("/repo/flatfs/shard/v1/prefix/2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFlatfsBlockstore(dir, false); err == nil {
+		t.Fatal("expected error for mismatched sharding")
+	}
+}
+
+func TestFlatfsBlockstore_AllKeysKeepsCodec(t *testing.T) {
+	dir := t.TempDir()
+	bs, err := NewFlatfsBlockstore(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	ctx := context.Background()
+
+	data := []byte("hello codecs")
+	v0 := blockformat.NewBlock(data).Cid() // CIDv0, dag-pb
+	hash := v0.Hash()
+	want := map[cid.Cid]bool{
+		v0:                                  true,
+		cid.NewCidV1(cid.DagProtobuf, hash): true,
+		cid.NewCidV1(cid.DagCBOR, hash):     true,
+	}
+	for id := range want {
+		blk, err := blockformat.NewBlockWithCid(data, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a block written before CIDs were recorded
+	legacy := blockformat.NewBlock([]byte("legacy block")).Cid()
+	dataDir, path := bs.path(legacy)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("legacy block"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want[cid.NewCidV1(cid.Raw, legacy.Hash())] = true
+
+	got := map[cid.Cid]bool{}
+	ch, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range ch {
+		got[id] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("keys = %v, want %v", got, want)
+	}
+	for id := range want {
+		if !got[id] {
+			t.Fatalf("missing key %s in %v", id, got)
+		}
+	}
+
+	if err := bs.Delete(ctx, v0); err != nil {
+		t.Fatal(err)
+	}
+	ch, err = bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range ch {
+		if id.Hash().String() == hash.String() {
+			t.Fatalf("deleted block still listed as %s", id)
+		}
+	}
+}
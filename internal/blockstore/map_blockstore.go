@@ -0,0 +1,67 @@
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+)
+
+// MapBlockstore keeps blocks in memory. It is safe for concurrent use and is
+// intended for tests and throwaway nodes.
+type MapBlockstore struct {
+	mu     sync.RWMutex
+	blocks map[cid.Cid]blockformat.Block
+}
+
+// NewMapBlockstore returns an empty in-memory Blockstore.
+func NewMapBlockstore() *MapBlockstore {
+	return &MapBlockstore{blocks: make(map[cid.Cid]blockformat.Block)}
+}
+
+func (m *MapBlockstore) Put(ctx context.Context, block blockformat.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocks[block.Cid()] = block
+	return nil
+}
+
+func (m *MapBlockstore) Get(ctx context.Context, id cid.Cid) (blockformat.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blk, ok := m.blocks[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", id, ErrNotFound)
+	}
+	return blk, nil
+}
+
+func (m *MapBlockstore) Delete(ctx context.Context, id cid.Cid) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blocks, id)
+	return nil
+}
+
+func (m *MapBlockstore) Has(ctx context.Context, id cid.Cid) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.blocks[id]
+	return ok, nil
+}
+
+func (m *MapBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	m.mu.RLock()
+	keys := make([]cid.Cid, 0, len(m.blocks))
+	for k := range m.blocks {
+		keys = append(keys, k)
+	}
+	m.mu.RUnlock()
+	return keysChan(ctx, keys), nil
+}
+
+func (m *MapBlockstore) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	blockformat "github.com/ipfs/go-block-format"
+)
+
+func TestMapBlockstore_PutGetDeleteHas(t *testing.T) {
+	bs := NewMapBlockstore()
+	defer bs.Close()
+	ctx := context.Background()
+
+	blk := blockformat.NewBlock([]byte("hello memory"))
+	if has, err := bs.Has(ctx, blk.Cid()); err != nil || has {
+		t.Fatalf("expected block to be absent, has=%v err=%v", has, err)
+	}
+	if _, err := bs.Get(ctx, blk.Cid()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bs.Get(ctx, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.RawData()) != "hello memory" {
+		t.Fatalf("block data mismatch: got %q", got.RawData())
+	}
+
+	if err := bs.Delete(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := bs.Has(ctx, blk.Cid()); err != nil || has {
+		t.Fatalf("expected block to be deleted, has=%v err=%v", has, err)
+	}
+}
+
+func TestMapBlockstore_Concurrent(t *testing.T) {
+	bs := NewMapBlockstore()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			blk := blockformat.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+			if err := bs.Put(ctx, blk); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := bs.Get(ctx, blk.Cid()); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
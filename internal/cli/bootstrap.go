@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/config"
+)
+
+var (
+	bootstrapAddDefault bool
+	bootstrapRmAll      bool
+)
+
+func init() {
+	RootCmd.AddCommand(bootstrapCmd)
+	bootstrapCmd.AddCommand(bootstrapListCmd, bootstrapAddCmd, bootstrapRmCmd)
+	bootstrapAddCmd.Flags().BoolVar(&bootstrapAddDefault, "default", false, "add the default public bootstrap peers")
+	bootstrapRmCmd.Flags().BoolVar(&bootstrapRmAll, "all", false, "remove all bootstrap peers")
+}
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Manage the peers the daemon connects to on startup",
+	Long: `Manage the Bootstrap list in the repo config. The daemon dials these peers
+whenever it has too few connections and refreshes its DHT routing table
+afterwards. Changes take effect when the daemon restarts.`,
+}
+
+var bootstrapListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the configured bootstrap peers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := openRepoConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read config: %v\n", err)
+			os.Exit(1)
+		}
+		for _, addr := range cfg.Bootstrap {
+			cmd.Println(addr)
+		}
+	},
+}
+
+var bootstrapAddCmd = &cobra.Command{
+	Use:   "add [multiaddr...]",
+	Short: "Add peers to the bootstrap list",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !bootstrapAddDefault {
+			return fmt.Errorf("requires a multiaddr or --default")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		addrs := args
+		if bootstrapAddDefault {
+			addrs = append(addrs, config.DefaultBootstrap...)
+		}
+		for _, addr := range addrs {
+			if _, err := peer.AddrInfoFromString(addr); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid bootstrap address %q: %v\n", addr, err)
+				os.Exit(1)
+			}
+		}
+		var added []string
+		err := updateConfig(func(cfg *config.Config) error {
+			for _, addr := range addrs {
+				if !slices.Contains(cfg.Bootstrap, addr) {
+					cfg.Bootstrap = append(cfg.Bootstrap, addr)
+					added = append(added, addr)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update config: %v\n", err)
+			os.Exit(1)
+		}
+		for _, addr := range added {
+			cmd.Println("added", addr)
+		}
+	},
+}
+
+var bootstrapRmCmd = &cobra.Command{
+	Use:   "rm [multiaddr...]",
+	Short: "Remove peers from the bootstrap list",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !bootstrapRmAll {
+			return fmt.Errorf("requires a multiaddr or --all")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var removed []string
+		err := updateConfig(func(cfg *config.Config) error {
+			var kept []string
+			for _, addr := range cfg.Bootstrap {
+				if bootstrapRmAll || slices.Contains(args, addr) {
+					removed = append(removed, addr)
+					continue
+				}
+				kept = append(kept, addr)
+			}
+			cfg.Bootstrap = kept
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update config: %v\n", err)
+			os.Exit(1)
+		}
+		for _, addr := range removed {
+			cmd.Println("removed", addr)
+		}
+	},
+}
@@ -2,29 +2,28 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"log"
 	"fmt"
 	"net/http"
-	"encoding/json"
-	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
+	"p2pfs/internal/api"
 	"p2pfs/internal/bitswap"
 	"p2pfs/internal/blockstore"
 	"p2pfs/internal/dag"
-	"github.com/ipfs/go-merkledag"
 	"p2pfs/internal/dag/exporter"
 	"p2pfs/internal/dag/importer"
-	"p2pfs/internal/datastore"
 	"p2pfs/internal/p2p"
+	"p2pfs/internal/pin"
+	"p2pfs/internal/repo"
 	"p2pfs/internal/routing"
-	"time"
-	"github.com/multiformats/go-multiaddr"
 )
 
 // RootCmd is the base command for the p2pfs CLI.
@@ -34,26 +33,75 @@ var RootCmd = &cobra.Command{
 }
 
 func init() {
+	// cobra's Print family writes to stderr unless an output is set;
+	// command results belong on stdout so they can be piped
+	RootCmd.SetOut(os.Stdout)
 	RootCmd.AddCommand(addCmd, getCmd, pinCmd, catCmd, lsCmd, demoCmd, serveCmd)
-	serveCmd.Flags().IntVarP(&servePort, "port", "p", 8080, "port to serve on")
+	addCmd.Flags().BoolVar(&addNoProvide, "no-provide", false, "do not announce the added content to the network, now or on reprovides")
+	serveCmd.Flags().IntVarP(&servePort, "port", "p", 8080, "port to serve the web interface and read-only routes on, on all interfaces")
+	serveCmd.Flags().BoolVar(&serveOfflineMemory, "offline-memory", false, "keep all data in memory; nothing is written to disk")
 }
 
+// Exit codes used by CLI commands. ExitNotFound lets scripts tell a missing
+// block apart from other failures.
+const (
+	ExitError    = 1
+	ExitNotFound = 2
+)
+
+// exitCode maps an error to the process exit code for it.
+func exitCode(err error) int {
+	if errors.Is(err, blockstore.ErrNotFound) {
+		return ExitNotFound
+	}
+	return ExitError
+}
+
+var addNoProvide bool
+
 var addCmd = &cobra.Command{
 	Use:   "add [file]",
 	Short: "Add a file to the P2P file system",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
+		if c := daemonClient(); c != nil {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "add failed: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			cidKey, err := c.Add(context.Background(), filepath.Base(args[0]), f, api.AddOptions{NoProvide: addNoProvide})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "add failed: %v\n", err)
+				os.Exit(1)
+			}
+			cmd.Println(cidKey.String())
+			return
+		}
+
+		r, err := openRepo()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open datastore: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
 			os.Exit(1)
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
+		defer r.Close()
+		bs := r.Blockstore()
 
-		cidKey, err := importer.ImportFile(context.Background(), args[0], bs)
+		ctx := context.Background()
+		cidKey, err := importer.ImportFile(ctx, args[0], bs)
+		if err == nil {
+			// kept out of the reprovides too, not just the provide queue
+			err = routing.SetNoProvide(ctx, r.Datastore(), cidKey, addNoProvide)
+		}
+		if err == nil && !addNoProvide {
+			// announced by the next daemon started on this repo
+			var keys []cid.Cid
+			keys, err = routing.ProvideKeys(ctx, r.Config().Reprovider.Strategy, bs, cidKey)
+			if err == nil {
+				err = routing.NewProvideQueue(r.Datastore()).Enqueue(ctx, keys...)
+			}
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "add failed: %v\n", err)
 			os.Exit(1)
@@ -62,203 +110,72 @@ var addCmd = &cobra.Command{
 	},
 }
 
-var servePort int
+var (
+	servePort          int
+	serveOfflineMemory bool
+)
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start HTTP web interface",
+	Long: `Start a node with the web interface. The full HTTP API is served on
+Addresses.API, a loopback address by default, where other commands find it
+and the web interface can add files and connect to peers. The port given
+with --port listens on all interfaces and serves the web interface with
+the read-only routes only: node info, cat, get, ls, shared files, name
+resolution and the /ipfs and /ipns gateway. Name publishing, which signs
+with the node identity or keystore keys, is only served on Addresses.API.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Printf("Starting web server on :%d", servePort)
-		// initialize datastore and blockstore
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "datastore error: %v\n", err)
-			os.Exit(1)
+		var r *repo.Repo
+		// an in-memory node has no api file to record, so any free
+		// loopback port does
+		apiAddr := "127.0.0.1:0"
+		if serveOfflineMemory {
+			log.Printf("Using in-memory storage; data will be lost on exit")
+		} else {
+			var err error
+			if r, err = openRepo(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+				os.Exit(1)
+			}
+			defer r.Close()
+			apiAddr = r.Config().Addresses.API
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
 
-		// initialize P2P host, DHT, and Bitswap engine
-		host, err := p2p.NewHost(context.Background(), 0)
+		// initialize stores, P2P host, DHT, and Bitswap engine
+		n, err := startNode(r, false)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to create host: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to start node: %v\n", err)
 			os.Exit(1)
 		}
-		dhtEngine, err := routing.NewKademliaDHT(context.Background(), host)
+		defer n.Close()
+
+		srv := api.NewServer(n)
+		if r != nil {
+			stop, err := serveRouting(r.Config().Addresses.Routing, srv)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to serve routing: %v\n", err)
+				os.Exit(1)
+			}
+			defer stop()
+		}
+
+		web := http.NewServeMux()
+		web.Handle("/", http.FileServer(http.Dir("web")))
+		web.Handle("/debug/metrics/prometheus", promhttp.Handler())
+		srv.RegisterPublic(web)
+		stopWeb, err := serveBackground("Web", fmt.Sprintf(":%d", servePort), web)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to create dht: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to serve web interface: %v\n", err)
 			os.Exit(1)
 		}
-		if err := dhtEngine.Bootstrap(context.Background()); err != nil {
-			fmt.Fprintf(os.Stderr, "dht bootstrap warning: %v\n", err)
-		}
-		bsEngine := bitswap.NewBitswap(host, dhtEngine, bs)
-		// print this node's Peer ID and multiaddrs for P2P connections
-		log.Printf("Node ID: %s", host.ID().String())
-		for _, addr := range host.Addrs() {
-			log.Printf("Node address: %s/p2p/%s", addr.String(), host.ID().String())
-		}
+		defer stopWeb()
 
 		mux := http.NewServeMux()
-
-		metadataBucket := "metadata"
-		sharedFiles := make(map[string]string)
-		if data, err := ds.Get(context.Background(), metadataBucket, []byte("shared_meta")); err == nil {
-		    json.Unmarshal(data, &sharedFiles)
-		}
 		mux.Handle("/", http.FileServer(http.Dir("web")))
-
-		mux.HandleFunc("/api/add", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-				return
-			}
-			file, fh, err := r.FormFile("file")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			defer file.Close()
-			tmp, err := os.CreateTemp("", "upload-*")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			defer os.Remove(tmp.Name())
-			defer tmp.Close()
-			if _, err := io.Copy(tmp, file); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			cidKey, err := importer.ImportFile(context.Background(), tmp.Name(), bs)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]string{"cid": cidKey.String()})
-			// record uploaded file metadata and persist
-			sharedFiles[fh.Filename] = cidKey.String()
-			if metaBytes, err := json.Marshal(sharedFiles); err != nil {
-			    log.Printf("failed to marshal shared metadata: %v", err)
-			} else if err := ds.Put(context.Background(), metadataBucket, []byte("shared_meta"), metaBytes); err != nil {
-			    log.Printf("failed to persist shared metadata: %v", err)
-			}
-		})
-
-		mux.HandleFunc("/api/cat", func(w http.ResponseWriter, r *http.Request) {
-			cidStr := r.URL.Query().Get("cid")
-			cidKey, err := cid.Parse(cidStr)
-			if err != nil {
-				http.Error(w, "invalid cid", http.StatusBadRequest)
-				return
-			}
-			blk, err := bs.Get(context.Background(), cidKey)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(blk.RawData())
-
-			// record fetched block into shared metadata
-			if _, exists := sharedFiles[cidStr]; !exists {
-				sharedFiles[cidStr] = cidStr
-				if metaBytes, err := json.Marshal(sharedFiles); err != nil {
-					log.Printf("failed to marshal shared metadata after fetch: %v", err)
-				} else if err := ds.Put(context.Background(), metadataBucket, []byte("shared_meta"), metaBytes); err != nil {
-					log.Printf("failed to persist shared metadata after fetch: %v", err)
-				}
-			}
-		})
-
-		mux.HandleFunc("/api/ls", func(w http.ResponseWriter, r *http.Request) {
-			cidStr := r.URL.Query().Get("cid")
-			cidKey, err := cid.Parse(cidStr)
-			if err != nil {
-				http.Error(w, "invalid cid", http.StatusBadRequest)
-				return
-			}
-			blk, err := bs.Get(context.Background(), cidKey)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			nodeProto, err := merkledag.DecodeProtobuf(blk.RawData())
-			var links []map[string]string
-			if err != nil {
-				links = []map[string]string{}
-			} else {
-				links = make([]map[string]string, len(nodeProto.Links()))
-				for i, link := range nodeProto.Links() {
-					links[i] = map[string]string{"name": link.Name, "cid": link.Cid.String()}
-				}
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(links)
-		})
-
-		// P2P connect endpoint
-		mux.HandleFunc("/api/connect", func(w http.ResponseWriter, r *http.Request) {
-			addrStr := r.URL.Query().Get("addr")
-			if addrStr == "" {
-				http.Error(w, "addr query param required", http.StatusBadRequest)
-				return
-			}
-			maddr, err := multiaddr.NewMultiaddr(addrStr)
-			if err != nil {
-				http.Error(w, "invalid multiaddr", http.StatusBadRequest)
-				return
-			}
-			info, err := peer.AddrInfoFromP2pAddr(maddr)
-			if err != nil {
-				http.Error(w, "invalid peer addr", http.StatusBadRequest)
-				return
-			}
-			ctxDial, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-			defer cancel()
-			if err := host.Connect(ctxDial, *info); err != nil {
-				http.Error(w, "connect failed: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]string{"status": "connected"})
-		})
-
-		// P2P fetch endpoint via Bitswap
-		mux.HandleFunc("/api/fetch", func(w http.ResponseWriter, r *http.Request) {
-			cidStr := r.URL.Query().Get("cid")
-			cidKey, err := cid.Parse(cidStr)
-			if err != nil {
-				http.Error(w, "invalid cid", http.StatusBadRequest)
-				return
-			}
-			blk, err := bsEngine.GetBlock(context.Background(), cidKey)
-			if err != nil {
-				http.Error(w, "fetch error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(blk.RawData())
-
-			// record fetched block into shared metadata
-			if _, exists := sharedFiles[cidStr]; !exists {
-				sharedFiles[cidStr] = cidStr
-				if metaBytes, err := json.Marshal(sharedFiles); err != nil {
-					log.Printf("failed to marshal shared metadata after fetch: %v", err)
-				} else if err := ds.Put(context.Background(), metadataBucket, []byte("shared_meta"), metaBytes); err != nil {
-					log.Printf("failed to persist shared metadata after fetch: %v", err)
-				}
-			}
-		})
-
-		// Shared files listing
-		mux.HandleFunc("/api/shared", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(sharedFiles)
-		})
-
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", servePort), mux); err != nil {
+		mux.Handle("/debug/metrics/prometheus", promhttp.Handler())
+		srv.Register(mux)
+		if err := serveHTTP(apiAddr, mux, r); err != nil {
 			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 			os.Exit(1)
 		}
@@ -270,24 +187,33 @@ var getCmd = &cobra.Command{
 	Short: "Retrieve a file by CID",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
+		cidKey, err := cid.Parse(args[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open datastore: %v\n", err)
+			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
 			os.Exit(1)
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
+		if c := daemonClient(); c != nil {
+			data, err := c.Get(context.Background(), cidKey)
+			if err == nil {
+				err = os.WriteFile(args[1], data, 0666)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "get failed: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			return
+		}
 
-		cidKey, err := cid.Parse(args[0])
+		r, err := openRepo()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
 			os.Exit(1)
 		}
+		defer r.Close()
+		bs := r.Blockstore()
 		if err := exporter.ExportFile(context.Background(), cidKey, bs, args[1]); err != nil {
 			fmt.Fprintf(os.Stderr, "get failed: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 	},
 }
@@ -297,36 +223,65 @@ var pinCmd = &cobra.Command{
 	Short: "Pin a block locally",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
+		cidKey, err := cid.Parse(args[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open datastore: %v\n", err)
+			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
 			os.Exit(1)
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
+		if c := daemonClient(); c != nil {
+			res, err := c.Pin(context.Background(), cidKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pin failed: %v\n", err)
+				os.Exit(1)
+			}
+			if res.ProvideError != "" {
+				fmt.Fprintf(os.Stderr, "provide warning: %s\n", res.ProvideError)
+			}
+			fmt.Println("pinned", cidKey.String())
+			return
+		}
 
-		cidKey, err := cid.Parse(args[0])
+		r, err := openRepo()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+			os.Exit(1)
+		}
+		defer r.Close()
+		bs := r.Blockstore()
+		if err := pin.NewStore(r.Datastore()).Add(context.Background(), cidKey); err != nil {
+			fmt.Fprintf(os.Stderr, "pin failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := routing.SetNoProvide(context.Background(), r.Datastore(), cidKey, false); err != nil {
+			fmt.Fprintf(os.Stderr, "pin failed: %v\n", err)
 			os.Exit(1)
 		}
+		if r.Config().Routing.Type == routing.TypeDelegated {
+			// no DHT to announce the pin to
+			fmt.Println("pinned", cidKey.String())
+			return
+		}
 
-		host, err := p2p.NewHost(context.Background(), 0)
+		hostOpts, err := hostOptions(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load identity: %v\n", err)
+			os.Exit(1)
+		}
+		host, err := p2p.NewHost(context.Background(), hostOpts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to create host: %v\n", err)
 			os.Exit(1)
 		}
-		dht, err := routing.NewKademliaDHT(context.Background(), host)
+		dht, err := routing.NewKademliaDHT(context.Background(), host, routing.Options{Private: hostOpts.PSK != nil})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to create dht: %v\n", err)
 			os.Exit(1)
 		}
 		bsEngine := bitswap.NewBitswap(host, dht, bs)
+		// a short-lived node rarely has DHT peers; the daemon's reprovider
+		// announces the pin later
 		if err := bsEngine.ProvideBlock(context.Background(), cidKey); err != nil {
-			fmt.Fprintf(os.Stderr, "pin failed: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "provide warning: %v\n", err)
 		}
 		fmt.Println("pinned", cidKey.String())
 	},
@@ -337,25 +292,32 @@ var catCmd = &cobra.Command{
 	Short: "Print block raw data",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
+		cidKey, err := cid.Parse(args[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open datastore: %v\n", err)
+			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
 			os.Exit(1)
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
+		if c := daemonClient(); c != nil {
+			data, err := c.Cat(context.Background(), cidKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cat failed: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			cmd.Print(string(data))
+			return
+		}
 
-		cidKey, err := cid.Parse(args[0])
+		r, err := openRepo()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
 			os.Exit(1)
 		}
+		defer r.Close()
+		bs := r.Blockstore()
 		blk, err := bs.Get(context.Background(), cidKey)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "cat failed: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 		cmd.Print(string(blk.RawData()))
 	},
@@ -366,25 +328,34 @@ var lsCmd = &cobra.Command{
 	Short: "List links in a DAG node",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		dbPath := "p2pfs.db"
-		ds, err := datastore.NewBboltDatastore(dbPath, 0600, nil)
+		cidKey, err := cid.Parse(args[0])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open datastore: %v\n", err)
+			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
 			os.Exit(1)
 		}
-		defer ds.Close()
-		bs := blockstore.NewBboltBlockstore(ds)
-		defer bs.Close()
+		if c := daemonClient(); c != nil {
+			links, err := c.Ls(context.Background(), cidKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ls failed: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			for _, link := range links {
+				cmd.Printf("%s\t%s\n", link.Name, link.Cid)
+			}
+			return
+		}
 
-		cidKey, err := cid.Parse(args[0])
+		r, err := openRepo()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
 			os.Exit(1)
 		}
+		defer r.Close()
+		bs := r.Blockstore()
 		blk, err := bs.Get(context.Background(), cidKey)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "ls failed: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 		node, err := dag.DecodeNode(blk.RawData())
 		if err != nil {
@@ -403,25 +374,18 @@ var demoCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 		// setup node A
-		dirA, _ := os.MkdirTemp("", "nodeA")
-		dsA, err := datastore.NewBboltDatastore(filepath.Join(dirA, "dbA.db"), 0600, nil)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nodeA datastore error: %v\n", err)
-			os.Exit(1)
-		}
-		defer dsA.Close()
-		bsA := blockstore.NewBboltBlockstore(dsA)
+		bsA := blockstore.NewMapBlockstore()
 		defer bsA.Close()
-		hostA, err := p2p.NewHost(ctx, 0)
+		hostA, err := p2p.NewHost(ctx, p2p.Options{})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "nodeA host error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Node A ID:", hostA.ID().String())
+		cmd.Println("Node A ID:", hostA.ID().String())
 		for _, addr := range hostA.Addrs() {
-			fmt.Printf("Node A address: %s/p2p/%s\n", addr.String(), hostA.ID().String())
+			cmd.Printf("Node A address: %s/p2p/%s\n", addr.String(), hostA.ID().String())
 		}
-		dhtA, err := routing.NewKademliaDHT(ctx, hostA)
+		dhtA, err := routing.NewKademliaDHT(ctx, hostA, routing.Options{})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "nodeA dht error: %v\n", err)
 			os.Exit(1)
@@ -433,25 +397,18 @@ var demoCmd = &cobra.Command{
 		bsEngA := bitswap.NewBitswap(hostA, dhtA, bsA)
 
 		// setup node B
-		dirB, _ := os.MkdirTemp("", "nodeB")
-		dsB, err := datastore.NewBboltDatastore(filepath.Join(dirB, "dbB.db"), 0600, nil)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nodeB datastore error: %v\n", err)
-			os.Exit(1)
-		}
-		defer dsB.Close()
-		bsB := blockstore.NewBboltBlockstore(dsB)
+		bsB := blockstore.NewMapBlockstore()
 		defer bsB.Close()
-		hostB, err := p2p.NewHost(ctx, 0)
+		hostB, err := p2p.NewHost(ctx, p2p.Options{})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "nodeB host error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Node B ID:", hostB.ID().String())
+		cmd.Println("Node B ID:", hostB.ID().String())
 		for _, addr := range hostB.Addrs() {
-			fmt.Printf("Node B address: %s/p2p/%s\n", addr.String(), hostB.ID().String())
+			cmd.Printf("Node B address: %s/p2p/%s\n", addr.String(), hostB.ID().String())
 		}
-		dhtB, err := routing.NewKademliaDHT(ctx, hostB)
+		dhtB, err := routing.NewKademliaDHT(ctx, hostB, routing.Options{})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "nodeB dht error: %v\n", err)
 			os.Exit(1)
@@ -467,7 +424,7 @@ var demoCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "connect error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Node B connected to Node A")
+		cmd.Println("Node B connected to Node A")
 
 		// import & provide on A
 		cidKey, err := importer.ImportFile(ctx, args[0], bsA)
@@ -485,11 +442,16 @@ var demoCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "get block error: %v\n", err)
 			os.Exit(1)
 		}
+		dirB, err := os.MkdirTemp("", "nodeB")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nodeB output dir error: %v\n", err)
+			os.Exit(1)
+		}
 		outPath := filepath.Join(dirB, filepath.Base(args[0]))
 		if err := os.WriteFile(outPath, blk.RawData(), 0644); err != nil {
 			fmt.Fprintf(os.Stderr, "write file error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Demo completed. Node B stored file at", outPath)
+		cmd.Println("Demo completed. Node B stored file at", outPath)
 	},
 }
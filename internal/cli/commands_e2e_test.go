@@ -2,12 +2,46 @@ package cli
 
 import (
 	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	blockformat "github.com/ipfs/go-block-format"
+
+	"p2pfs/internal/api"
+	"p2pfs/internal/config"
+	"p2pfs/internal/node"
+	"p2pfs/internal/repo"
 )
 
+// captureOutput sends the output of RootCmd to fresh stdout and stderr
+// buffers until the test ends, then restores the defaults.
+func captureOutput(t *testing.T) (stdout, stderr *bytes.Buffer) {
+	t.Helper()
+	stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+	RootCmd.SetOut(stdout)
+	RootCmd.SetErr(stderr)
+	t.Cleanup(func() {
+		RootCmd.SetOut(os.Stdout)
+		RootCmd.SetErr(nil)
+	})
+	return stdout, stderr
+}
+
+// TestCLIResultsOnStdout checks where results printed with cmd.Print* go
+// when nothing redirects them. Cobra sends them to stderr by default, which
+// leaves nothing to pipe into another command.
+func TestCLIResultsOnStdout(t *testing.T) {
+	if RootCmd.OutOrStderr() != os.Stdout {
+		t.Fatal("command results do not go to os.Stdout")
+	}
+}
+
 // TestCLIAddCatLs performs an end-to-end test of the add, cat, and ls CLI commands.
 func TestCLIAddCatLs(t *testing.T) {
 	// Set up a temporary working directory.
@@ -27,19 +61,27 @@ func TestCLIAddCatLs(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Point the CLI at a fresh repo inside the temp directory.
+	t.Setenv("P2PFS_PATH", filepath.Join(tmpDir, "repo"))
+
 	// Create an input file.
 	inputFile := filepath.Join(tmpDir, "input.txt")
 	if err := os.WriteFile(inputFile, []byte("hello e2e"), 0644); err != nil {
 		t.Fatal(err)
 	}
 
+	// Run `p2pfs init`
+	buf, errBuf := captureOutput(t)
+	RootCmd.SetArgs([]string{"init"})
+	if err := RootCmd.Execute(); err != nil {
+		t.Fatalf("init failed: %v, output: %s", err, errBuf.String())
+	}
+	buf.Reset()
+
 	// Run `p2pfs add`
-	buf := new(bytes.Buffer)
-	RootCmd.SetOut(buf)
-	RootCmd.SetErr(buf)
 	RootCmd.SetArgs([]string{"add", inputFile})
 	if err := RootCmd.Execute(); err != nil {
-		t.Fatalf("add failed: %v, output: %s", err, buf.String())
+		t.Fatalf("add failed: %v, output: %s", err, errBuf.String())
 	}
 	cid := strings.TrimSpace(buf.String())
 	if cid == "" {
@@ -48,8 +90,6 @@ func TestCLIAddCatLs(t *testing.T) {
 	buf.Reset()
 
 	// Run `p2pfs cat`
-	RootCmd.SetOut(buf)
-	RootCmd.SetErr(buf)
 	RootCmd.SetArgs([]string{"cat", cid})
 	if err := RootCmd.Execute(); err != nil {
 		t.Fatalf("cat failed: %v", err)
@@ -60,8 +100,6 @@ func TestCLIAddCatLs(t *testing.T) {
 	buf.Reset()
 
 	// Run `p2pfs ls` (should produce no links for a raw block)
-	RootCmd.SetOut(buf)
-	RootCmd.SetErr(buf)
 	RootCmd.SetArgs([]string{"ls", cid})
 	if err := RootCmd.Execute(); err != nil {
 		t.Fatalf("ls failed: %v", err)
@@ -72,8 +110,6 @@ func TestCLIAddCatLs(t *testing.T) {
 
 	// Run `p2pfs demo` to show P2P file sharing
 	buf.Reset()
-	RootCmd.SetOut(buf)
-	RootCmd.SetErr(buf)
 	RootCmd.SetArgs([]string{"demo", inputFile})
 	if err := RootCmd.Execute(); err != nil {
 		t.Fatalf("demo failed: %v", err)
@@ -93,3 +129,122 @@ func TestCLIAddCatLs(t *testing.T) {
 		t.Fatalf("expected demo file content 'hello e2e', got '%s'", string(data))
 	}
 }
+
+// TestCLIBootstrap edits the bootstrap list through the bootstrap commands.
+func TestCLIBootstrap(t *testing.T) {
+	t.Setenv("P2PFS_PATH", filepath.Join(t.TempDir(), "repo"))
+	stdout, stderr := captureOutput(t)
+	run := func(args ...string) string {
+		t.Helper()
+		stdout.Reset()
+		stderr.Reset()
+		RootCmd.SetArgs(args)
+		if err := RootCmd.Execute(); err != nil {
+			t.Fatalf("%v failed: %v, output: %s", args, err, stderr.String())
+		}
+		return stdout.String()
+	}
+	lines := func(s string) []string { return strings.Fields(s) }
+
+	run("init")
+	if got := lines(run("bootstrap", "list")); len(got) != len(config.DefaultBootstrap) {
+		t.Fatalf("expected the default bootstrap peers, got %v", got)
+	}
+	run("bootstrap", "rm", "--all")
+	if got := run("bootstrap", "list"); got != "" {
+		t.Fatalf("expected empty bootstrap list, got %q", got)
+	}
+	addr := "/ip4/192.0.2.1/tcp/4001/p2p/12D3KooWPe9ARETGvgtFfucdzbx9dvUKLHBm6nVjKSRgQsshAs6B"
+	run("bootstrap", "add", addr)
+	run("bootstrap", "add", addr)
+	if got := lines(run("bootstrap", "list")); len(got) != 1 || got[0] != addr {
+		t.Fatalf("expected only %s, got %v", addr, got)
+	}
+}
+
+// runCLI returns a function running RootCmd with the given arguments and
+// returning what it printed to stdout. The test fails if the command fails
+// or writes to stderr.
+func runCLI(t *testing.T) func(args ...string) string {
+	stdout, stderr := captureOutput(t)
+	return func(args ...string) string {
+		t.Helper()
+		stdout.Reset()
+		stderr.Reset()
+		RootCmd.SetArgs(args)
+		if err := RootCmd.Execute(); err != nil {
+			t.Fatalf("%v failed: %v, output: %s", args, err, stderr.String())
+		}
+		if stderr.Len() != 0 {
+			t.Fatalf("%v wrote to stderr: %s", args, stderr.String())
+		}
+		return stdout.String()
+	}
+}
+
+// startTestDaemon serves the API of an in-memory node, standing in for a
+// daemon on the repo at path, and returns the node and its API address.
+func startTestDaemon(t *testing.T, path string) (*node.Node, string) {
+	t.Helper()
+	n, err := node.New(context.Background(), node.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	mux := http.NewServeMux()
+	api.NewServer(n).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	if err := repo.WriteAPIAddr(path, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	return n, srv.URL
+}
+
+// TestCLIPubsub runs the pubsub commands against a daemon API and checks
+// that their results reach stdout.
+func TestCLIPubsub(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo")
+	t.Setenv("P2PFS_PATH", path)
+	run := runCLI(t)
+	run("init")
+	_, addr := startTestDaemon(t, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sub, err := api.NewClient(addr).PubsubSubscribe(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if got := run("pubsub", "ls"); got != "news\n" {
+		t.Fatalf("pubsub ls printed %q", got)
+	}
+	run("pubsub", "pub", "news", "hello")
+	m, err := sub.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "hello" {
+		t.Fatalf("received %q", m.Data)
+	}
+}
+
+// TestCLIRoutingFindprovs checks that routing findprovs prints the providers
+// it finds to stdout.
+func TestCLIRoutingFindprovs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo")
+	t.Setenv("P2PFS_PATH", path)
+	run := runCLI(t)
+	run("init")
+	n, _ := startTestDaemon(t, path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := blockformat.NewBlock([]byte("provided by the daemon")).Cid()
+	// recorded locally even though the DHT has no peers to replicate it to
+	n.DHT.Provide(ctx, c, true)
+	if got := run("routing", "findprovs", c.String()); got != n.Host.ID().String()+"\n" {
+		t.Fatalf("routing findprovs printed %q, want %s", got, n.Host.ID())
+	}
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/api"
+	"p2pfs/internal/node"
+	"p2pfs/internal/repo"
+)
+
+var daemonEnableMDNS bool
+
+func init() {
+	RootCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().BoolVar(&daemonEnableMDNS, "enable-mdns", false, "discover peers on the local network (overrides Discovery.MDNS.Enabled)")
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run a long-lived node serving the local HTTP API",
+	Long: `Run a node that owns the repo, the libp2p host, the DHT and Bitswap, and
+serves the HTTP API on Addresses.API. While it runs, other commands on the
+same repo are routed through it. If Addresses.Routing is set, the Routing V1
+provider lookup alone is also served there for other nodes.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		r, err := openRepo()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+			os.Exit(1)
+		}
+		defer r.Close()
+
+		n, err := startNode(r, daemonEnableMDNS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start node: %v\n", err)
+			os.Exit(1)
+		}
+		defer n.Close()
+
+		srv := api.NewServer(n)
+		stop, err := serveRouting(r.Config().Addresses.Routing, srv)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to serve routing: %v\n", err)
+			os.Exit(1)
+		}
+		defer stop()
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/metrics/prometheus", promhttp.Handler())
+		srv.Register(mux)
+		if err := serveHTTP(r.Config().Addresses.API, mux, r); err != nil {
+			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// startNode starts a node backed by r, or an in-memory node if r is nil,
+// and logs its addresses. enableMDNS forces local network discovery on.
+func startNode(r *repo.Repo, enableMDNS bool) (*node.Node, error) {
+	n, err := node.New(context.Background(), node.Config{
+		Repo:       r,
+		Passphrase: []byte(os.Getenv(repo.EnvPassphrase)),
+		Registerer: prometheus.DefaultRegisterer,
+		EnableMDNS: enableMDNS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// print this node's Peer ID and multiaddrs for P2P connections
+	log.Printf("Node ID: %s", n.Host.ID().String())
+	for _, addr := range n.Host.Addrs() {
+		log.Printf("Node address: %s/p2p/%s", addr.String(), n.Host.ID().String())
+	}
+	return n, nil
+}
+
+// serveRouting serves the Routing V1 endpoint of srv, and nothing else, on
+// addr in the background. An empty addr serves nothing. stop closes the
+// listener.
+func serveRouting(addr string, srv *api.Server) (stop func(), err error) {
+	if addr == "" {
+		return func() {}, nil
+	}
+	mux := http.NewServeMux()
+	srv.RegisterRouting(mux)
+	return serveBackground("Routing V1", addr, mux)
+}
+
+// serveBackground serves handler on addr until stop is called. what names
+// the server in log messages.
+func serveBackground(what, addr string, handler http.Handler) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s server listening on %s", what, ln.Addr())
+	hs := &http.Server{Handler: handler}
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("%s server error: %v", what, err)
+		}
+	}()
+	return func() { hs.Close() }, nil
+}
+
+// serveHTTP serves handler on addr until SIGINT or SIGTERM. If r is non-nil
+// the listening address is recorded in the repo's api file while serving so
+// other commands can find the node.
+func serveHTTP(addr string, handler http.Handler, r *repo.Repo) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("API server listening on %s", ln.Addr())
+	if r != nil {
+		if err := repo.WriteAPIAddr(r.Path(), ln.Addr().String()); err != nil {
+			ln.Close()
+			return err
+		}
+		defer repo.RemoveAPIAddr(r.Path())
+	}
+
+	srv := &http.Server{Handler: handler}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+var dhtQueryTimeout time.Duration
+
+func init() {
+	RootCmd.AddCommand(dhtCmd)
+	dhtCmd.AddCommand(dhtPeersCmd, dhtQueryCmd)
+	dhtQueryCmd.Flags().DurationVar(&dhtQueryTimeout, "timeout", time.Minute, "give up the query after this long")
+}
+
+var dhtCmd = &cobra.Command{
+	Use:   "dht",
+	Short: "Inspect the DHT of the running daemon",
+}
+
+var dhtPeersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Print the DHT routing table",
+	Long: `Print the DHT mode and the peers in each routing table bucket of the
+running daemon. Buckets are numbered by the length of the prefix their
+peers share with this node's DHT key.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := daemonClient()
+		if c == nil {
+			fmt.Fprintln(os.Stderr, "dht peers needs a running daemon (start one with 'p2pfs daemon')")
+			os.Exit(1)
+		}
+		table, err := c.DHTTable(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dht peers failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Printf("Mode: %s\n", table.Mode)
+		for _, b := range table.Buckets {
+			cmd.Printf("Bucket %d (%d peers):\n", b.CommonPrefixLen, len(b.Peers))
+			for _, p := range b.Peers {
+				cmd.Printf("  %s  added %s\n", p.ID, p.AddedAt.Format(time.RFC3339))
+			}
+		}
+	},
+}
+
+var dhtQueryCmd = &cobra.Command{
+	Use:   "query [peerid]",
+	Short: "Find the peers closest to a peer ID in the DHT",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := peer.Decode(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid peer id: %v\n", err)
+			os.Exit(1)
+		}
+		c := daemonClient()
+		if c == nil {
+			fmt.Fprintln(os.Stderr, "dht query needs a running daemon (start one with 'p2pfs daemon')")
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dhtQueryTimeout)
+		defer cancel()
+		peers, err := c.DHTQuery(ctx, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dht query failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, p := range peers {
+			cmd.Println(p)
+		}
+	},
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/p2p"
+)
+
+func init() {
+	RootCmd.AddCommand(idCmd)
+}
+
+var idCmd = &cobra.Command{
+	Use:   "id",
+	Short: "Show this node's Peer ID, public key and addresses",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var info p2p.Info
+		if c := daemonClient(); c != nil {
+			var err error
+			if info, err = c.ID(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "id failed: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			r, err := openRepo()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+				os.Exit(1)
+			}
+			defer r.Close()
+			hostOpts, err := hostOptions(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to load identity: %v\n", err)
+				os.Exit(1)
+			}
+			host, err := p2p.NewHost(context.Background(), hostOpts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to create host: %v\n", err)
+				os.Exit(1)
+			}
+			defer host.Close()
+			if info, err = p2p.HostInfo(host); err != nil {
+				fmt.Fprintf(os.Stderr, "id failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+	},
+}
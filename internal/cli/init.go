@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/repo"
+)
+
+var (
+	initBlockstore   string
+	initIdentityFile string
+)
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVar(&initBlockstore, "blockstore", "bbolt", "blockstore backend (bbolt|flatfs)")
+	initCmd.Flags().StringVar(&initIdentityFile, "identity-file", "", "use the private key in this file, as written by 'key export', as the node identity instead of generating one")
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize a p2pfs repo",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := resolveRepoPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "init failed: %v\n", err)
+			os.Exit(1)
+		}
+		cfg := config.Default()
+		cfg.Datastore.Blockstore = initBlockstore
+		// a passphrase in the environment encrypts the identity at rest
+		passphrase := []byte(os.Getenv(repo.EnvPassphrase))
+		if initIdentityFile != "" {
+			var sk crypto.PrivKey
+			data, err := os.ReadFile(initIdentityFile)
+			if err == nil {
+				sk, err = crypto.UnmarshalPrivateKey(data)
+			}
+			if err == nil {
+				err = repo.InitWithIdentity(path, cfg, sk, passphrase)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "init failed: %v\n", err)
+				os.Exit(1)
+			}
+		} else if err := repo.Init(path, cfg, passphrase); err != nil {
+			fmt.Fprintf(os.Stderr, "init failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Println("initialized p2pfs repo at", path)
+		cmd.Println("peer identity:", cfg.Identity.PeerID)
+	},
+}
@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/repo"
+)
+
+var (
+	keyGenType     string
+	keyGenBits     int
+	keyListLong    bool
+	keyRenameForce bool
+	keyExportOut   string
+)
+
+func init() {
+	RootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyGenCmd, keyListCmd, keyRenameCmd, keyRmCmd, keyExportCmd, keyImportCmd)
+	keyGenCmd.Flags().StringVarP(&keyGenType, "type", "t", repo.KeyTypeEd25519, "key type: ed25519 or rsa")
+	keyGenCmd.Flags().IntVarP(&keyGenBits, "size", "s", repo.DefaultRSAKeySize, "size of RSA keys in bits")
+	keyListCmd.Flags().BoolVarP(&keyListLong, "long", "l", false, "print the name each key publishes to as well")
+	keyRenameCmd.Flags().BoolVarP(&keyRenameForce, "force", "f", false, "overwrite an existing key of the new name")
+	keyExportCmd.Flags().StringVarP(&keyExportOut, "output", "o", "", "file to write the key to (default <name>.key)")
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage the keys of the repo keystore",
+	Long: `Keys sign name records (see 'p2pfs name publish --key'). They are stored
+in the repo's keystore directory, readable only by the owner, and encrypted
+with $P2PFS_PASSPHRASE if it is set. The name "self" refers to the node
+identity, which can be exported but not replaced; an exported key becomes
+the identity of a new repo with 'p2pfs init --identity-file'.`,
+}
+
+// openKeystore returns the keystore and path of the selected repo. It does
+// not open the repo, so it works while a daemon is running.
+func openKeystore() (*repo.Keystore, string) {
+	path, err := resolveRepoPath()
+	if err == nil && !repo.IsInitialized(path) {
+		err = fmt.Errorf("%s: %w (run 'p2pfs init')", path, repo.ErrNotInitialized)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+		os.Exit(1)
+	}
+	// keys written while P2PFS_PASSPHRASE is set are encrypted with it
+	ks, err := repo.OpenKeystore(path, []byte(os.Getenv(repo.EnvPassphrase)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open keystore: %v\n", err)
+		os.Exit(1)
+	}
+	return ks, path
+}
+
+// keyName returns the IPNS name derived from sk.
+func keyName(sk crypto.PrivKey) (ipns.Name, error) {
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return ipns.Name{}, err
+	}
+	return ipns.NameFromPeer(pid), nil
+}
+
+var keyGenCmd = &cobra.Command{
+	Use:   "gen [name]",
+	Short: "Generate a new key and print the name it publishes to",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, _ := openKeystore()
+		sk, err := repo.GenerateKey(keyGenType, keyGenBits)
+		if err == nil {
+			err = ks.Put(args[0], sk)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key gen failed: %v\n", err)
+			os.Exit(1)
+		}
+		name, err := keyName(sk)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key gen failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Println(name)
+	},
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys, starting with self",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, path := openKeystore()
+		names, err := ks.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key list failed: %v\n", err)
+			os.Exit(1)
+		}
+		names = append([]string{repo.SelfKey}, names...)
+		if !keyListLong {
+			for _, n := range names {
+				cmd.Println(n)
+			}
+			return
+		}
+		cfg, err := openRepoConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key list failed: %v\n", err)
+			os.Exit(1)
+		}
+		// the identity may be encrypted; its peer ID is in the config
+		self, err := peer.Decode(cfg.Identity.PeerID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key list failed: %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		cmd.Printf("%s\t%s\n", ipns.NameFromPeer(self), repo.SelfKey)
+		for _, n := range names[1:] {
+			sk, err := ks.Get(n)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "key list failed: %v\n", err)
+				os.Exit(1)
+			}
+			name, err := keyName(sk)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "key list failed: %s: %v\n", n, err)
+				os.Exit(1)
+			}
+			cmd.Printf("%s\t%s\n", name, n)
+		}
+	},
+}
+
+var keyRenameCmd = &cobra.Command{
+	Use:   "rename [old] [new]",
+	Short: "Rename a key",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, _ := openKeystore()
+		if err := ks.Rename(args[0], args[1], keyRenameForce); err != nil {
+			fmt.Fprintf(os.Stderr, "key rename failed: %v\n", err)
+			os.Exit(keyExitCode(err))
+		}
+	},
+}
+
+var keyRmCmd = &cobra.Command{
+	Use:   "rm [name]...",
+	Short: "Remove keys",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, _ := openKeystore()
+		for _, n := range args {
+			if err := ks.Delete(n); err != nil {
+				fmt.Fprintf(os.Stderr, "key rm failed: %v\n", err)
+				os.Exit(keyExitCode(err))
+			}
+			cmd.Println("removed", n)
+		}
+	},
+}
+
+var keyExportCmd = &cobra.Command{
+	Use:   "export [name]",
+	Short: "Write a private key to a file",
+	Long: `Write a private key, in the libp2p protobuf encoding, to a new file that
+only the owner can read. Exporting "self" reads the node identity, using
+$P2PFS_PASSPHRASE if it is encrypted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, path := openKeystore()
+		var sk crypto.PrivKey
+		var err error
+		if args[0] == repo.SelfKey {
+			sk, err = repo.ReadIdentity(path, []byte(os.Getenv(repo.EnvPassphrase)))
+		} else {
+			sk, err = ks.Get(args[0])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key export failed: %v\n", err)
+			os.Exit(keyExitCode(err))
+		}
+		data, err := crypto.MarshalPrivateKey(sk)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key export failed: %v\n", err)
+			os.Exit(1)
+		}
+		out := keyExportOut
+		if out == "" {
+			out = args[0] + ".key"
+		}
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key export failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Println(out)
+	},
+}
+
+var keyImportCmd = &cobra.Command{
+	Use:   "import [name] [file]",
+	Short: "Add a private key exported with 'key export' to the keystore",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ks, _ := openKeystore()
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key import failed: %v\n", err)
+			os.Exit(1)
+		}
+		sk, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key import failed: %s is not a private key: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		if err := ks.Put(args[0], sk); err != nil {
+			fmt.Fprintf(os.Stderr, "key import failed: %v\n", err)
+			os.Exit(1)
+		}
+		name, err := keyName(sk)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "key import failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Println(name)
+	},
+}
+
+// keyExitCode returns ExitNotFound for missing keys.
+func keyExitCode(err error) int {
+	if errors.Is(err, repo.ErrKeyNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return ExitNotFound
+	}
+	return ExitError
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/api"
+	"p2pfs/internal/namesys"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+var (
+	namePublishKey      string
+	namePublishLifetime time.Duration
+	namePublishTTL      time.Duration
+	nameTimeout         time.Duration
+)
+
+func init() {
+	RootCmd.AddCommand(nameCmd)
+	nameCmd.AddCommand(namePublishCmd, nameResolveCmd)
+	nameCmd.PersistentFlags().DurationVar(&nameTimeout, "timeout", time.Minute, "give up after this long")
+	namePublishCmd.Flags().StringVarP(&namePublishKey, "key", "k", repo.SelfKey, "name of the key to publish with (see 'p2pfs key list')")
+	namePublishCmd.Flags().DurationVar(&namePublishLifetime, "lifetime", ipns.DefaultRecordLifetime, "how long the record stays valid")
+	namePublishCmd.Flags().DurationVar(&namePublishTTL, "ttl", ipns.DefaultRecordTTL, "how long resolvers may cache the record")
+}
+
+var nameCmd = &cobra.Command{
+	Use:   "name",
+	Short: "Publish and resolve mutable names",
+	Long: `A name is the hash of a public key, printed as /ipns/<name>. Publishing
+signs a record pointing the name of a key at a content path and stores it
+in the DHT; anyone can resolve the name to the latest path. The node
+identity key "self" is used unless another key is given.`,
+}
+
+var namePublishCmd = &cobra.Command{
+	Use:   "publish [cid|path]",
+	Short: "Point the name of a key at a CID or content path",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		value, err := api.ParseContentPath(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), nameTimeout)
+		defer cancel()
+		if c := daemonClient(); c != nil {
+			res, err := c.NamePublish(ctx, value.String(), api.NamePublishOptions{
+				Key:      namePublishKey,
+				Lifetime: namePublishLifetime,
+				TTL:      namePublishTTL,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "publish failed: %v\n", err)
+				os.Exit(1)
+			}
+			if res.PublishError != "" {
+				fmt.Fprintf(os.Stderr, "publish warning: %s\n", res.PublishError)
+			}
+			cmd.Printf("Published to %s: %s\n", res.Name, res.Value)
+			return
+		}
+
+		names := startOfflineNames()
+		defer names.close()
+		sk, err := names.key(namePublishKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "publish failed: %v\n", err)
+			os.Exit(1)
+		}
+		pid, err := peer.IDFromPrivateKey(sk)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "publish failed: %v\n", err)
+			os.Exit(1)
+		}
+		_, err = names.Publish(ctx, sk, value, namesys.PublishOptions{Lifetime: namePublishLifetime, TTL: namePublishTTL})
+		if errors.Is(err, namesys.ErrRoutingPut) {
+			// a short-lived node rarely has DHT peers; the record still
+			// resolves locally and reaches the DHT on the next publish
+			fmt.Fprintf(os.Stderr, "publish warning: %v\n", err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "publish failed: %v\n", err)
+			os.Exit(1)
+		}
+		cmd.Printf("Published to %s: %s\n", ipns.NameFromPeer(pid), value)
+	},
+}
+
+var nameResolveCmd = &cobra.Command{
+	Use:   "resolve [name]",
+	Short: "Print the path a name points at",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := ipns.NameFromString(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid name: %v\n", err)
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), nameTimeout)
+		defer cancel()
+		if c := daemonClient(); c != nil {
+			p, err := c.NameResolve(ctx, name.String())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "resolve failed: %v\n", err)
+				os.Exit(exitCode(err))
+			}
+			cmd.Println(p)
+			return
+		}
+
+		names := startOfflineNames()
+		defer names.close()
+		p, err := names.Resolve(ctx, name.AsPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "resolve failed: %v\n", err)
+			if errors.Is(err, namesys.ErrNotFound) {
+				os.Exit(ExitNotFound)
+			}
+			os.Exit(1)
+		}
+		cmd.Println(p)
+	},
+}
+
+// offlineNames is a short-lived name system on the repo for commands run
+// without a daemon: a host on ports picked by the OS and a DHT on it, with
+// records cached in the repo datastore. Unlike a daemon it does not listen
+// on the Swarm addresses, bootstrap or announce content.
+type offlineNames struct {
+	*namesys.NameSystem
+	repo  *repo.Repo
+	self  crypto.PrivKey
+	close func()
+}
+
+func startOfflineNames() *offlineNames {
+	r, err := openRepo()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+		os.Exit(1)
+	}
+	hostOpts, err := hostOptions(r)
+	if err != nil {
+		r.Close()
+		fmt.Fprintf(os.Stderr, "failed to load identity: %v\n", err)
+		os.Exit(1)
+	}
+	if r.Config().Routing.Type == routing.TypeDelegated {
+		// without a DHT names only reach the local cache
+		return &offlineNames{
+			NameSystem: namesys.New(routing.NoValueStore{}, r.Datastore()),
+			repo:       r,
+			self:       hostOpts.Identity,
+			close:      func() { r.Close() },
+		}
+	}
+	host, err := p2p.NewHost(context.Background(), hostOpts)
+	if err != nil {
+		r.Close()
+		fmt.Fprintf(os.Stderr, "failed to create host: %v\n", err)
+		os.Exit(1)
+	}
+	dht, err := routing.NewKademliaDHT(context.Background(), host, routing.Options{Private: hostOpts.PSK != nil})
+	if err != nil {
+		host.Close()
+		r.Close()
+		fmt.Fprintf(os.Stderr, "failed to create dht: %v\n", err)
+		os.Exit(1)
+	}
+	return &offlineNames{
+		NameSystem: namesys.New(dht, r.Datastore()),
+		repo:       r,
+		self:       hostOpts.Identity,
+		close: func() {
+			dht.Close()
+			host.Close()
+			r.Close()
+		},
+	}
+}
+
+// key returns the private key called name: the node identity for
+// repo.SelfKey, otherwise a key from the repo keystore.
+func (o *offlineNames) key(name string) (crypto.PrivKey, error) {
+	if name == "" || name == repo.SelfKey {
+		return o.self, nil
+	}
+	ks, err := o.repo.Keystore([]byte(os.Getenv(repo.EnvPassphrase)))
+	if err != nil {
+		return nil, err
+	}
+	return ks.Get(name)
+}
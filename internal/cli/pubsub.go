@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/api"
+	"p2pfs/internal/pubsub"
+)
+
+var pubsubSubVerbose bool
+
+func init() {
+	RootCmd.AddCommand(pubsubCmd)
+	pubsubCmd.AddCommand(pubsubPubCmd, pubsubSubCmd, pubsubLsCmd, pubsubPeersCmd)
+	pubsubSubCmd.Flags().BoolVarP(&pubsubSubVerbose, "verbose", "v", false, "print the sender and sequence number before each message")
+}
+
+var pubsubCmd = &cobra.Command{
+	Use:   "pubsub",
+	Short: "Send and receive messages on topics through the running daemon",
+	Long: `Messages published on a topic reach every peer subscribed to it that
+is connected to the daemon, directly or through other subscribers. Each
+message is signed with the identity key of the node that published it and
+dropped by any peer the signature does not check out for.`,
+}
+
+// pubsubClient returns the client of the running daemon, which holds the
+// subscriptions; cmd names the command in the error.
+func pubsubClient(cmd string) *api.Client {
+	c := daemonClient()
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "pubsub %s needs a running daemon (start one with 'p2pfs daemon')\n", cmd)
+		os.Exit(1)
+	}
+	return c
+}
+
+var pubsubPubCmd = &cobra.Command{
+	Use:   "pub [topic] [data]",
+	Short: "Publish a message on a topic",
+	Long: `Publish data as a message on topic. Without a data argument the message
+is read from standard input.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := pubsubClient("pub")
+		var data []byte
+		if len(args) == 2 {
+			data = []byte(args[1])
+		} else {
+			var err error
+			data, err = io.ReadAll(io.LimitReader(os.Stdin, pubsub.MaxMessageSize+1))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pubsub pub failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := c.PubsubPublish(context.Background(), args[0], data); err != nil {
+			fmt.Fprintf(os.Stderr, "pubsub pub failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var pubsubSubCmd = &cobra.Command{
+	Use:   "sub [topic]",
+	Short: "Print the messages published on a topic until interrupted",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := pubsubClient("sub")
+		sub, err := c.PubsubSubscribe(context.Background(), args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pubsub sub failed: %v\n", err)
+			os.Exit(1)
+		}
+		defer sub.Close()
+		for {
+			m, err := sub.Next()
+			if errors.Is(err, io.EOF) {
+				// the daemon shut down
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pubsub sub failed: %v\n", err)
+				os.Exit(1)
+			}
+			if pubsubSubVerbose {
+				cmd.Printf("%s %d: ", m.From, m.Seqno)
+			}
+			cmd.Printf("%s\n", m.Data)
+		}
+	},
+}
+
+var pubsubLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the topics the daemon subscribes to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		topics, err := pubsubClient("ls").PubsubTopics(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pubsub ls failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, t := range topics {
+			cmd.Println(t)
+		}
+	},
+}
+
+var pubsubPeersCmd = &cobra.Command{
+	Use:   "peers [topic]",
+	Short: "List the peers exchanging messages with the daemon",
+	Long: `List the connected peers that subscribe to topic, or all connected
+peers speaking pubsub if no topic is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var topic string
+		if len(args) == 1 {
+			topic = args[0]
+		}
+		peers, err := pubsubClient("peers").PubsubPeers(context.Background(), topic)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pubsub peers failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, p := range peers {
+			cmd.Println(p)
+		}
+	},
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+
+	"p2pfs/internal/bitswap"
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+// quarantineBucket holds the raw bytes of blocks removed by `repo verify`.
+const quarantineBucket = "quarantine"
+
+var (
+	verifyQuarantine bool
+	verifyRefetch    bool
+	verifyPeers      []string
+)
+
+func init() {
+	RootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoVerifyCmd)
+	repoCmd.AddCommand(repoMigrateCmd)
+	repoVerifyCmd.Flags().BoolVar(&verifyQuarantine, "quarantine", false, "move corrupt blocks out of the blockstore")
+	repoVerifyCmd.Flags().BoolVar(&verifyRefetch, "refetch", false, "replace corrupt blocks with copies fetched from peers")
+	repoVerifyCmd.Flags().StringArrayVar(&verifyPeers, "peer", nil, "multiaddr of a peer to fetch from (repeatable)")
+}
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manage the local repository",
+}
+
+var repoVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check every stored block against its CID",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		r, err := openRepo()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open repo: %v\n", err)
+			os.Exit(1)
+		}
+		defer r.Close()
+		ds, bs := r.Datastore(), r.Blockstore()
+		// read the stored bytes as-is; verification happens below
+		if h, ok := bs.(interface{ HashOnRead(bool) }); ok {
+			h.HashOnRead(false)
+		}
+
+		keys, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list blocks: %v\n", err)
+			os.Exit(1)
+		}
+		var total int
+		var corrupt []cid.Cid
+		for id := range keys {
+			total++
+			blk, err := bs.Get(ctx, id)
+			if err == nil {
+				err = blockstore.VerifyBlock(blk)
+			}
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, blockstore.ErrHashMismatch) {
+				cmd.Printf("corrupt %s: data does not match hash\n", id)
+			} else {
+				cmd.Printf("corrupt %s: %v\n", id, err)
+			}
+			corrupt = append(corrupt, id)
+			if !verifyQuarantine && !verifyRefetch {
+				continue
+			}
+			if blk != nil {
+				if err := ds.Put(ctx, quarantineBucket, id.Bytes(), blk.RawData()); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to quarantine %s: %v\n", id, err)
+					os.Exit(1)
+				}
+			}
+			if err := bs.Delete(ctx, id); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to remove %s: %v\n", id, err)
+				os.Exit(1)
+			}
+			cmd.Printf("quarantined %s\n", id)
+		}
+		cmd.Printf("verified %d blocks, %d corrupt\n", total, len(corrupt))
+
+		remaining := len(corrupt)
+		if verifyRefetch && len(corrupt) > 0 {
+			hostOpts, err := hostOptions(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to load identity: %v\n", err)
+				os.Exit(1)
+			}
+			remaining = refetchBlocks(ctx, cmd, hostOpts, bs, corrupt)
+		}
+		if remaining > 0 {
+			os.Exit(ExitError)
+		}
+	},
+}
+
+var repoMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the repository layout to the current version",
+	Long: `Upgrade the repository layout to the current version. The datastore is
+backed up next to the original before any change is made. Opening an older
+repo migrates it automatically; this command does so without starting a node.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := resolveRepoPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to resolve repo path: %v\n", err)
+			os.Exit(1)
+		}
+		res, err := repo.Migrate(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migration failed: %v\n", err)
+			os.Exit(1)
+		}
+		if res.From == res.To {
+			cmd.Printf("repo is at version %d, nothing to migrate\n", res.To)
+			return
+		}
+		if res.Backup != "" {
+			cmd.Printf("backed up datastore to %s\n", res.Backup)
+		}
+		cmd.Printf("migrated repo from version %d to %d\n", res.From, res.To)
+	},
+}
+
+// refetchBlocks fetches each CID from the network into bs and returns how
+// many could not be recovered.
+func refetchBlocks(ctx context.Context, cmd *cobra.Command, hostOpts p2p.Options, bs blockstore.Blockstore, ids []cid.Cid) int {
+	host, err := p2p.NewHost(ctx, hostOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create host: %v\n", err)
+		os.Exit(1)
+	}
+	defer host.Close()
+	dht, err := routing.NewKademliaDHT(ctx, host, routing.Options{Private: hostOpts.PSK != nil})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create dht: %v\n", err)
+		os.Exit(1)
+	}
+	var peers []peer.AddrInfo
+	for _, addr := range verifyPeers {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid peer addr %q: %v\n", addr, err)
+			os.Exit(1)
+		}
+		if err := host.Connect(ctx, *info); err != nil {
+			fmt.Fprintf(os.Stderr, "connect to %s failed: %v\n", info.ID, err)
+		}
+		peers = append(peers, *info)
+	}
+	if err := dht.Bootstrap(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "dht bootstrap warning: %v\n", err)
+	}
+	// the given peers are asked for every block along with DHT providers
+	router := routing.NewParallelRouter(dht, routing.NewStaticRouter(peers))
+	engine := bitswap.NewBitswap(host, router, bs)
+
+	failed := 0
+	for _, id := range ids {
+		if _, err := engine.GetBlock(ctx, id); err != nil {
+			if errors.Is(err, blockstore.ErrNotFound) {
+				cmd.Printf("refetch %s: no peer has it\n", id)
+			} else {
+				cmd.Printf("refetch %s: %v\n", id, err)
+			}
+			failed++
+			continue
+		}
+		cmd.Printf("refetched %s\n", id)
+	}
+	return failed
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"p2pfs/internal/api"
+	"p2pfs/internal/config"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/repo"
+)
+
+// repoPath is the --repo flag; empty means $P2PFS_PATH or ~/.p2pfs.
+var repoPath string
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&repoPath, "repo", "", "path to the p2pfs repo (default $P2PFS_PATH or ~/.p2pfs)")
+}
+
+// resolveRepoPath returns the repo directory selected by flags and environment.
+func resolveRepoPath() (string, error) {
+	if repoPath != "" {
+		return repoPath, nil
+	}
+	return repo.DefaultPath()
+}
+
+// openRepo opens the selected repo, failing if it has not been initialized.
+func openRepo() (*repo.Repo, error) {
+	path, err := resolveRepoPath()
+	if err != nil {
+		return nil, err
+	}
+	return repo.Open(path)
+}
+
+// openRepoConfig loads the config of the selected repo without opening the
+// repo, so it can be read while a daemon holds the repo.
+func openRepoConfig() (*config.Config, error) {
+	path, err := resolveRepoPath()
+	if err != nil {
+		return nil, err
+	}
+	if !repo.IsInitialized(path) {
+		return nil, fmt.Errorf("%s: %w (run 'p2pfs init')", path, repo.ErrNotInitialized)
+	}
+	return config.Load(filepath.Join(path, repo.ConfigFile))
+}
+
+// updateConfig applies fn to the config of the selected repo and saves it.
+// The config file is edited in place so this works while a daemon holds the
+// repo; the daemon picks up the change when it restarts.
+func updateConfig(fn func(cfg *config.Config) error) error {
+	cfg, err := openRepoConfig()
+	if err != nil {
+		return err
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	path, err := resolveRepoPath()
+	if err != nil {
+		return err
+	}
+	return cfg.Save(filepath.Join(path, repo.ConfigFile))
+}
+
+// hostOptions returns the libp2p host options for a short-lived node backed
+// by r, using the repo identity, swarm key, announce filters and connection
+// gater. It listens on ephemeral ports rather than Addresses.Swarm, which
+// belong to the daemon. The passphrase for an encrypted identity is read from
+// $P2PFS_PASSPHRASE.
+func hostOptions(r *repo.Repo) (p2p.Options, error) {
+	sk, err := r.Identity([]byte(os.Getenv(repo.EnvPassphrase)))
+	if err != nil {
+		return p2p.Options{}, err
+	}
+	psk, err := r.SwarmKey()
+	if err != nil {
+		return p2p.Options{}, err
+	}
+	cfg := r.Config()
+	return p2p.Options{
+		Announce:   cfg.Addresses.Announce,
+		NoAnnounce: cfg.Addresses.NoAnnounce,
+		Identity:   sk,
+		PSK:        psk,
+		Gater: p2p.GaterOptions{
+			AllowedPeers: cfg.Swarm.Gater.AllowedPeers,
+			DeniedPeers:  cfg.Swarm.Gater.DeniedPeers,
+			DeniedAddrs:  cfg.Swarm.Gater.DeniedAddrs,
+		},
+	}, nil
+}
+
+// daemonClient returns a client for the daemon serving the selected repo, or
+// nil if none is running. A stale api file left by a crashed daemon is
+// treated as no daemon.
+func daemonClient() *api.Client {
+	path, err := resolveRepoPath()
+	if err != nil {
+		return nil
+	}
+	addr, err := repo.APIAddr(path)
+	if err != nil {
+		return nil
+	}
+	c := api.NewClient(addr)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.ID(ctx); err != nil {
+		return nil
+	}
+	return c
+}
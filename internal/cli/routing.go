@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	findprovsNum     int
+	findprovsTimeout time.Duration
+	findprovsVerbose bool
+)
+
+func init() {
+	RootCmd.AddCommand(routingCmd)
+	routingCmd.AddCommand(routingFindprovsCmd)
+	routingFindprovsCmd.Flags().IntVarP(&findprovsNum, "num-providers", "n", 20, "stop after this many providers (at most 20)")
+	routingFindprovsCmd.Flags().DurationVar(&findprovsTimeout, "timeout", time.Minute, "give up the search after this long")
+	routingFindprovsCmd.Flags().BoolVarP(&findprovsVerbose, "verbose", "v", false, "print provider addresses as well")
+}
+
+var routingCmd = &cobra.Command{
+	Use:   "routing",
+	Short: "Query content routing",
+}
+
+var routingFindprovsCmd = &cobra.Command{
+	Use:   "findprovs [cid]",
+	Short: "Print the peers providing a CID as they are found",
+	Long: `Search the running daemon's DHT for providers of a CID and print each
+peer ID as soon as it is found.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cidKey, err := cid.Parse(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid cid: %v\n", err)
+			os.Exit(1)
+		}
+		c := daemonClient()
+		if c == nil {
+			fmt.Fprintln(os.Stderr, "routing findprovs needs a running daemon (start one with 'p2pfs daemon')")
+			os.Exit(1)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), findprovsTimeout)
+		defer cancel()
+		found := 0
+		err = c.Router().FindProvidersFunc(ctx, cidKey, func(pi peer.AddrInfo) bool {
+			found++
+			if findprovsVerbose {
+				cmd.Println(pi.ID, pi.Addrs)
+			} else {
+				cmd.Println(pi.ID)
+			}
+			return found < findprovsNum
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "findprovs failed: %v\n", err)
+			os.Exit(1)
+		}
+		if found == 0 {
+			fmt.Fprintln(os.Stderr, "no providers found")
+			os.Exit(ExitNotFound)
+		}
+	},
+}
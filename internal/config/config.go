@@ -0,0 +1,321 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CurrentVersion is the config format written by this build. Configs with a
+// higher version are rejected rather than misread.
+const CurrentVersion = 1
+
+// Config is the on-disk configuration of a p2pfs repo.
+type Config struct {
+	Version   int
+	Identity  Identity
+	Datastore Datastore
+	Addresses Addresses
+	// Bootstrap lists the multiaddrs (with /p2p/ peer IDs) of the peers the
+	// daemon connects to on startup to join the network.
+	Bootstrap  []string
+	Discovery  Discovery
+	Swarm      Swarm
+	Bitswap    Bitswap
+	Reprovider Reprovider
+	Routing    Routing
+}
+
+// Routing configures how providers of content are found.
+type Routing struct {
+	// Type is "dht" to run the Kademlia DHT, or "delegated" for clients
+	// too lightweight to run one: providers are then only found through
+	// StaticProviders and DelegatedRouters, content is not announced and
+	// names are not published to or resolved from the network.
+	Type string
+	// StaticProviders are multiaddrs (with /p2p/ peer IDs) of peers asked
+	// for every block, alongside the providers found in the DHT.
+	StaticProviders []string
+	// DelegatedRouters are base URLs of Routing V1 HTTP servers, such as
+	// another p2pfs daemon's API address, queried alongside the DHT.
+	DelegatedRouters []string
+	DHT              DHT
+}
+
+// DHT configures the Kademlia DHT.
+type DHT struct {
+	// Mode is "auto" (server once publicly reachable), "client" or
+	// "server".
+	Mode string
+	// BucketSize is the number of peers per routing table bucket.
+	BucketSize int
+	// PersistProviders keeps provider records in the repo datastore so
+	// they survive restarts.
+	PersistProviders bool
+}
+
+// Reprovider configures how often and which content the node re-announces
+// to the DHT. Provider records expire after about 24 hours.
+type Reprovider struct {
+	// Interval between reprovide runs; 0 disables reproviding.
+	Interval Duration
+	// Strategy selects the CIDs announced: "all" blocks, the blocks of
+	// "pinned" DAGs, or only the pinned "roots".
+	Strategy string
+}
+
+// Bitswap configures block exchange.
+type Bitswap struct {
+	// ProtectedRoots are DAGs whose blocks are only served to listed peers.
+	ProtectedRoots []ProtectedRoot
+}
+
+// ProtectedRoot restricts the DAG under Cid to AllowedPeers.
+type ProtectedRoot struct {
+	Cid          string
+	AllowedPeers []string
+}
+
+// Swarm configures connection and resource limits and NAT traversal of the
+// libp2p host.
+type Swarm struct {
+	ConnMgr     ConnMgr
+	ResourceMgr ResourceMgr
+	// EnableAutoNAT lets peers ask this node whether they are publicly
+	// reachable. The node always checks its own reachability.
+	EnableAutoNAT bool
+	// EnableHolePunching upgrades relayed connections to direct ones.
+	EnableHolePunching bool
+	RelayClient        RelayClient
+	RelayService       RelayService
+	Gater              Gater
+}
+
+// Gater restricts which peers the node connects to.
+type Gater struct {
+	// AllowedPeers, if non-empty, are the only peers the node talks to.
+	AllowedPeers []string
+	// DeniedPeers are never connected to.
+	DeniedPeers []string
+	// DeniedAddrs are CIDR filters (/ip4/10.0.0.0/ipcidr/8) for addresses
+	// that are neither dialed nor accepted.
+	DeniedAddrs []string
+}
+
+// RelayClient configures the use of circuit relays while the node is not
+// publicly reachable.
+type RelayClient struct {
+	Enabled bool
+	// StaticRelays are the relays to use. If empty, relays are picked
+	// among connected peers.
+	StaticRelays []string
+}
+
+// RelayService configures relaying for other peers while the node is
+// publicly reachable.
+type RelayService struct {
+	Enabled bool
+}
+
+// ConnMgr configures connection trimming. Once more than HighWater peers are
+// connected, connections are closed down to LowWater, sparing connections
+// younger than GracePeriod and protected peers.
+type ConnMgr struct {
+	LowWater    int
+	HighWater   int
+	GracePeriod Duration
+}
+
+// ResourceMgr caps what the libp2p host may use. Zero values keep libp2p's
+// defaults, which are scaled to the machine's memory and file descriptors.
+type ResourceMgr struct {
+	MaxMemory         int64 // bytes
+	MaxConnections    int
+	MaxStreams        int
+	MaxStreamsPerPeer int
+}
+
+// Duration is a time.Duration stored as a string such as "20s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Discovery configures how the daemon finds peers besides the DHT.
+type Discovery struct {
+	MDNS MDNS
+}
+
+// MDNS configures discovery of peers on the local network.
+type MDNS struct {
+	Enabled bool
+}
+
+// DefaultBootstrap are the public libp2p bootstrap peers of the IPFS DHT.
+var DefaultBootstrap = []string{
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+	"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+}
+
+// Addresses configures where the node listens.
+type Addresses struct {
+	// API is the host:port the daemon serves its HTTP API on.
+	API string
+	// Routing, if set, is the host:port the read-only Routing V1 endpoint
+	// is served on for other nodes, without the rest of the API.
+	Routing string
+	// Swarm lists the multiaddrs the daemon accepts peer connections on.
+	Swarm []string
+	// Announce, if non-empty, is advertised to peers instead of the
+	// listen addresses.
+	Announce []string
+	// NoAnnounce lists multiaddrs or CIDR filters (/ip4/10.0.0.0/ipcidr/8)
+	// that are never advertised.
+	NoAnnounce []string
+}
+
+// Identity records the node's Peer ID. The private key itself is kept in a
+// separate file in the repo so it can be encrypted.
+type Identity struct {
+	PeerID string
+}
+
+// Datastore configures block and metadata storage.
+type Datastore struct {
+	// Blockstore selects the block backend: "bbolt" or "flatfs".
+	Blockstore string
+	// HashOnRead re-hashes every block read from disk.
+	HashOnRead bool
+	// SyncWrites fsyncs flatfs block files before they become visible.
+	SyncWrites bool
+	// BlockCache configures the in-memory cache used by long-running nodes.
+	BlockCache BlockCache
+}
+
+// BlockCache configures the read-through block cache.
+type BlockCache struct {
+	Policy          string // "arc" or "lru"
+	Size            int    // number of cached blocks
+	BloomFilterSize int    // bytes; zero disables the bloom filter
+}
+
+// Default returns the configuration written by `p2pfs init`.
+func Default() *Config {
+	return &Config{
+		Version: CurrentVersion,
+		Datastore: Datastore{
+			Blockstore: "bbolt",
+			SyncWrites: true,
+			BlockCache: BlockCache{
+				Policy:          "arc",
+				Size:            256,
+				BloomFilterSize: 512 << 10,
+			},
+		},
+		Addresses: Addresses{
+			API: "127.0.0.1:5001",
+			Swarm: []string{
+				"/ip4/0.0.0.0/tcp/4001",
+				"/ip6/::/tcp/4001",
+				"/ip4/0.0.0.0/udp/4001/quic-v1",
+				"/ip6/::/udp/4001/quic-v1",
+				"/ip4/0.0.0.0/tcp/4002/ws",
+				"/ip6/::/tcp/4002/ws",
+			},
+			NoAnnounce: []string{
+				"/ip4/127.0.0.0/ipcidr/8",
+				"/ip6/::1/ipcidr/128",
+				"/ip4/169.254.0.0/ipcidr/16",
+				"/ip6/fe80::/ipcidr/10",
+				"/ip4/10.0.0.0/ipcidr/8",
+				"/ip4/172.16.0.0/ipcidr/12",
+				"/ip4/192.168.0.0/ipcidr/16",
+				"/ip4/100.64.0.0/ipcidr/10",
+				"/ip6/fc00::/ipcidr/7",
+			},
+		},
+		Bootstrap: append([]string(nil), DefaultBootstrap...),
+		Swarm: Swarm{
+			ConnMgr: ConnMgr{
+				LowWater:    32,
+				HighWater:   96,
+				GracePeriod: Duration(20 * time.Second),
+			},
+			EnableAutoNAT:      true,
+			EnableHolePunching: true,
+			RelayClient:        RelayClient{Enabled: true},
+			RelayService:       RelayService{Enabled: true},
+		},
+		Reprovider: Reprovider{
+			Interval: Duration(12 * time.Hour),
+			Strategy: "all",
+		},
+		Routing: Routing{
+			Type: "dht",
+			DHT: DHT{
+				Mode:             "auto",
+				BucketSize:       20,
+				PersistProviders: true,
+			},
+		},
+	}
+}
+
+// Load reads the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := Default()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.Version > CurrentVersion {
+		return nil, fmt.Errorf("config %s has version %d, this build supports up to %d", path, cfg.Version, CurrentVersion)
+	}
+	return cfg, nil
+}
+
+// Save writes cfg to path, replacing any existing file atomically.
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	cfg := Default()
+	cfg.Datastore.Blockstore = "flatfs"
+	cfg.Swarm.ConnMgr.GracePeriod = Duration(90 * time.Second)
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Datastore.Blockstore != "flatfs" || got.Version != CurrentVersion {
+		t.Fatalf("unexpected config after round trip: %+v", got)
+	}
+	if got.Swarm.ConnMgr.GracePeriod != cfg.Swarm.ConnMgr.GracePeriod {
+		t.Fatalf("grace period = %v, want %v", time.Duration(got.Swarm.ConnMgr.GracePeriod), time.Duration(cfg.Swarm.ConnMgr.GracePeriod))
+	}
+}
+
+func TestLoadRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(`{"Version": 99}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for newer config version")
+	}
+}
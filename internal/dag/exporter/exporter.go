@@ -1,33 +1,33 @@
 package exporter
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"os"
 
 	"github.com/ipfs/go-cid"
-	merkledag "github.com/ipfs/go-merkledag"
 
 	"p2pfs/internal/blockstore"
 )
 
-// ExportFile retrieves the block for root CID and writes its raw data to path.
-func ExportFile(ctx context.Context, root cid.Cid, bs blockstore.Blockstore, path string) error {
+// Export retrieves the block for root CID and writes the file it holds to
+// w, reversing importer.ImportFile. Nothing is written if the block is
+// missing.
+func Export(ctx context.Context, root cid.Cid, bs blockstore.Blockstore, w io.Writer) error {
 	blk, err := bs.Get(ctx, root)
 	if err != nil {
 		return err
 	}
+	_, err = w.Write(blk.RawData())
+	return err
+}
 
-	node, err := merkledag.DecodeProtobuf(blk.RawData())
-	if err != nil {
-		return err
-	}
-
-	f, err := os.Create(path)
-	if err != nil {
+// ExportFile retrieves the block for root CID and writes its raw data to path.
+func ExportFile(ctx context.Context, root cid.Cid, bs blockstore.Blockstore, path string) error {
+	var buf bytes.Buffer
+	if err := Export(ctx, root, bs, &buf); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	_, err = f.Write(node.RawData())
-	return err
+	return os.WriteFile(path, buf.Bytes(), 0666)
 }
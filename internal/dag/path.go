@@ -0,0 +1,44 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+)
+
+// ErrNoLink is returned by ResolvePath when a node on the path has no link
+// of the requested name.
+var ErrNoLink = errors.New("dag: no link by that name")
+
+// ResolvePath follows the named links in segments from root and returns the
+// CID they lead to. get fetches the nodes on the way, e.g. Blockstore.Get or
+// Bitswap.GetBlock.
+func ResolvePath(ctx context.Context, get func(context.Context, cid.Cid) (blockformat.Block, error), root cid.Cid, segments []string) (cid.Cid, error) {
+	id := root
+	for _, name := range segments {
+		if name == "" {
+			continue
+		}
+		if id.Type() != cid.DagProtobuf {
+			return cid.Undef, fmt.Errorf("%s/%s: %w", id, name, ErrNoLink)
+		}
+		blk, err := get(ctx, id)
+		if err != nil {
+			return cid.Undef, err
+		}
+		node, err := merkledag.DecodeProtobuf(blk.RawData())
+		if err != nil {
+			return cid.Undef, fmt.Errorf("%s/%s: %w", id, name, ErrNoLink)
+		}
+		link, err := node.GetNodeLink(name)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("%s/%s: %w", id, name, ErrNoLink)
+		}
+		id = link.Cid
+	}
+	return id, nil
+}
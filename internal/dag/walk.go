@@ -0,0 +1,48 @@
+package dag
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+
+	"p2pfs/internal/blockstore"
+)
+
+// Walk calls fn for root and every block reachable from it through DAG
+// links, visiting each CID once. Blocks missing from bs are reported to fn
+// but not descended into, so a partially stored DAG can still be walked.
+func Walk(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, fn func(cid.Cid) error) error {
+	seen := cid.NewSet()
+	queue := []cid.Cid{root}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if !seen.Visit(id) {
+			continue
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+		if id.Type() != cid.DagProtobuf {
+			continue
+		}
+		blk, err := bs.Get(ctx, id)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		node, err := merkledag.DecodeProtobuf(blk.RawData())
+		if err != nil {
+			// raw data stored under a dag-pb CID has no links
+			continue
+		}
+		for _, link := range node.Links() {
+			queue = append(queue, link.Cid)
+		}
+	}
+	return nil
+}
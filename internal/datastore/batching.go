@@ -0,0 +1,99 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	ipfsds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// batching adapts one bucket of a Datastore to the go-datastore interface
+// used by libp2p components such as the DHT provider store.
+type batching struct {
+	ds     Datastore
+	bucket string
+}
+
+// NewBatching returns a go-datastore view of bucket in d. Closing it does
+// not close d.
+func NewBatching(d Datastore, bucket string) ipfsds.Batching {
+	return &batching{ds: d, bucket: bucket}
+}
+
+func (b *batching) Get(ctx context.Context, key ipfsds.Key) ([]byte, error) {
+	v, err := b.ds.Get(ctx, b.bucket, key.Bytes())
+	if errors.Is(err, ErrNotFound) {
+		return nil, ipfsds.ErrNotFound
+	}
+	return v, err
+}
+
+func (b *batching) Has(ctx context.Context, key ipfsds.Key) (bool, error) {
+	_, err := b.Get(ctx, key)
+	if errors.Is(err, ipfsds.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *batching) GetSize(ctx context.Context, key ipfsds.Key) (int, error) {
+	v, err := b.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+// Query scans the bucket for keys under q.Prefix and applies the rest of
+// the query in memory.
+func (b *batching) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	prefix := []byte(ipfsds.NewKey(q.Prefix).String())
+	if !bytes.HasSuffix(prefix, []byte("/")) {
+		prefix = append(prefix, '/')
+	}
+	var entries []query.Entry
+	err := b.ds.ForEach(ctx, b.bucket, func(key, value []byte) error {
+		if q.Prefix != "" && q.Prefix != "/" && !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		e := query.Entry{Key: string(key), Size: len(value)}
+		if !q.KeysOnly {
+			e.Value = bytes.Clone(value)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the prefix is already applied
+	q.Prefix = ""
+	return query.NaiveQueryApply(q, query.ResultsWithEntries(q, entries)), nil
+}
+
+func (b *batching) Put(ctx context.Context, key ipfsds.Key, value []byte) error {
+	return b.ds.Put(ctx, b.bucket, key.Bytes(), value)
+}
+
+func (b *batching) Delete(ctx context.Context, key ipfsds.Key) error {
+	err := b.ds.Delete(ctx, b.bucket, key.Bytes())
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Sync is a no-op: writes go straight to the underlying datastore.
+func (b *batching) Sync(ctx context.Context, prefix ipfsds.Key) error {
+	return nil
+}
+
+func (b *batching) Batch(ctx context.Context) (ipfsds.Batch, error) {
+	return ipfsds.NewBasicBatch(b), nil
+}
+
+func (b *batching) Close() error {
+	return nil
+}
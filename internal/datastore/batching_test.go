@@ -0,0 +1,65 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ipfsds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+func TestBatching(t *testing.T) {
+	d := NewMapDatastore()
+	defer d.Close()
+	ctx := context.Background()
+	b := NewBatching(d, "dht")
+
+	if _, err := b.Get(ctx, ipfsds.NewKey("/a/1")); !errors.Is(err, ipfsds.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	batch, err := b.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/a/1", "/a/2", "/ab/3", "/b/4"} {
+		if err := batch.Put(ctx, ipfsds.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// values live in the bucket of the underlying datastore
+	if v, err := d.Get(ctx, "dht", []byte("/b/4")); err != nil || string(v) != "/b/4" {
+		t.Fatalf("underlying datastore has %q, %v", v, err)
+	}
+
+	res, err := b.Query(ctx, query.Query{Prefix: "/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("prefix /a matched %v, want /a/1 and /a/2 only", entries)
+	}
+	for _, e := range entries {
+		if e.Key != string(e.Value) {
+			t.Fatalf("entry %s has value %q", e.Key, e.Value)
+		}
+	}
+
+	if err := b.Delete(ctx, ipfsds.NewKey("/a/1")); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := b.Has(ctx, ipfsds.NewKey("/a/1")); err != nil || has {
+		t.Fatalf("deleted key still present: %v, %v", has, err)
+	}
+	// deleting a missing key is not an error
+	if err := b.Delete(ctx, ipfsds.NewKey("/a/1")); err != nil {
+		t.Fatal(err)
+	}
+}
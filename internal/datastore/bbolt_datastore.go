@@ -2,7 +2,7 @@ package datastore
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"os"
 
 	bbolt "go.etcd.io/bbolt"
@@ -37,11 +37,11 @@ func (b *bboltDatastore) Get(ctx context.Context, bucket string, key []byte) ([]
 	err := b.db.View(func(tx *bbolt.Tx) error {
 		bkt := tx.Bucket([]byte(bucket))
 		if bkt == nil {
-			return errors.New("bucket not found")
+			return fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
 		}
 		v := bkt.Get(key)
 		if v == nil {
-			return errors.New("key not found")
+			return fmt.Errorf("key %x in bucket %q: %w", key, bucket, ErrNotFound)
 		}
 		val = append([]byte{}, v...)
 		return nil
@@ -56,12 +56,27 @@ func (b *bboltDatastore) Delete(ctx context.Context, bucket string, key []byte)
 	return b.db.Update(func(tx *bbolt.Tx) error {
 		bkt := tx.Bucket([]byte(bucket))
 		if bkt == nil {
-			return errors.New("bucket not found")
+			return fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
 		}
 		return bkt.Delete(key)
 	})
 }
 
+func (b *bboltDatastore) ForEach(ctx context.Context, bucket string, fn func(key, value []byte) error) error {
+	return b.db.View(func(tx *bbolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(k, v)
+		})
+	})
+}
+
 func (b *bboltDatastore) Close() error {
 	return b.db.Close()
 }
@@ -1,11 +1,22 @@
 package datastore
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned (possibly wrapped) when a bucket or key does not exist.
+var ErrNotFound = errors.New("datastore: not found")
 
 // Datastore defines a simple key-value store interface.
 type Datastore interface {
 	Put(ctx context.Context, bucket string, key []byte, value []byte) error
 	Get(ctx context.Context, bucket string, key []byte) ([]byte, error)
 	Delete(ctx context.Context, bucket string, key []byte) error
+	// ForEach calls fn for every key/value pair in bucket, in key order for
+	// ordered backends. A missing bucket is treated as empty. Iteration stops
+	// at the first error returned by fn. Slices passed to fn are only valid
+	// for the duration of the call.
+	ForEach(ctx context.Context, bucket string, fn func(key, value []byte) error) error
 	Close() error
 }
@@ -0,0 +1,87 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// mapDatastore implements Datastore in memory. It is safe for concurrent use.
+type mapDatastore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMapDatastore returns an empty in-memory Datastore. Nothing is persisted;
+// all data is lost once the datastore is garbage collected.
+func NewMapDatastore() Datastore {
+	return &mapDatastore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *mapDatastore) Put(ctx context.Context, bucket string, key []byte, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bkt, ok := m.buckets[bucket]
+	if !ok {
+		bkt = make(map[string][]byte)
+		m.buckets[bucket] = bkt
+	}
+	bkt[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *mapDatastore) Get(ctx context.Context, bucket string, key []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	bkt, ok := m.buckets[bucket]
+	if !ok {
+		return nil, fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
+	}
+	v, ok := bkt[string(key)]
+	if !ok {
+		return nil, fmt.Errorf("key %x in bucket %q: %w", key, bucket, ErrNotFound)
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (m *mapDatastore) Delete(ctx context.Context, bucket string, key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bkt, ok := m.buckets[bucket]
+	if !ok {
+		return fmt.Errorf("bucket %q: %w", bucket, ErrNotFound)
+	}
+	delete(bkt, string(key))
+	return nil
+}
+
+func (m *mapDatastore) ForEach(ctx context.Context, bucket string, fn func(key, value []byte) error) error {
+	// snapshot the bucket so fn may modify the datastore
+	m.mu.RLock()
+	bkt := m.buckets[bucket]
+	keys := make([]string, 0, len(bkt))
+	for k := range bkt {
+		keys = append(keys, k)
+	}
+	values := make(map[string][]byte, len(bkt))
+	for _, k := range keys {
+		values[k] = bkt[k]
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn([]byte(k), values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mapDatastore) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMapDatastore_PutGetDelete(t *testing.T) {
+	ds := NewMapDatastore()
+	defer ds.Close()
+	ctx := context.Background()
+
+	if _, err := ds.Get(ctx, "b", []byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing bucket, got %v", err)
+	}
+	if err := ds.Put(ctx, "b", []byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, "b", []byte("other")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for missing key, got %v", err)
+	}
+
+	v, err := ds.Get(ctx, "b", []byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	// returned values must not alias internal storage
+	v[0] = 'x'
+	if v, _ := ds.Get(ctx, "b", []byte("k")); string(v) != "v" {
+		t.Fatalf("stored value was modified through returned slice: %q", v)
+	}
+
+	if err := ds.Delete(ctx, "b", []byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, "b", []byte("k")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestMapDatastore_ForEach(t *testing.T) {
+	ds := NewMapDatastore()
+	ctx := context.Background()
+
+	if err := ds.ForEach(ctx, "missing", func(k, v []byte) error {
+		t.Fatalf("unexpected key %q in missing bucket", k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"c", "a", "b"} {
+		if err := ds.Put(ctx, "b", []byte(k), []byte("v"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	if err := ds.ForEach(ctx, "b", func(k, v []byte) error {
+		got = append(got, string(k)+"="+string(v))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a=va b=vb c=vc"; strings.Join(got, " ") != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
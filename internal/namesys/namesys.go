@@ -0,0 +1,274 @@
+// Package namesys publishes and resolves mutable names: signed IPNS records
+// mapping the hash of a public key to a content path.
+package namesys
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	corerouting "github.com/libp2p/go-libp2p/core/routing"
+
+	"p2pfs/internal/datastore"
+)
+
+// CacheBucket holds the latest known record of every name published or
+// resolved by this node, keyed by routing key.
+const CacheBucket = "ipns-cache"
+
+// maxResolveDepth bounds chains of names pointing at other names.
+const maxResolveDepth = 32
+
+// ErrNotFound is returned when no valid record exists for a name.
+var ErrNotFound = errors.New("namesys: no record found for name")
+
+// ErrRoutingPut wraps the error of storing a published record in the
+// routing system. The record is kept locally all the same, so the name
+// still resolves on this node.
+var ErrRoutingPut = errors.New("namesys: storing record in routing failed")
+
+// ValueStore stores and retrieves records by key, validating them. The DHT
+// implements it.
+type ValueStore interface {
+	PutValue(ctx context.Context, key string, value []byte) error
+	GetValue(ctx context.Context, key string) ([]byte, error)
+}
+
+// PublishOptions configures NameSystem.Publish.
+type PublishOptions struct {
+	// Lifetime is how long the record stays valid; 0 means
+	// ipns.DefaultRecordLifetime.
+	Lifetime time.Duration
+	// TTL is how long resolvers may cache the record; 0 means
+	// ipns.DefaultRecordTTL.
+	TTL time.Duration
+}
+
+// NameSystem publishes names to and resolves them from a ValueStore,
+// caching records in a datastore.
+type NameSystem struct {
+	store ValueStore
+	ds    datastore.Datastore
+}
+
+// New returns a name system storing records in store and caching them in ds.
+func New(store ValueStore, ds datastore.Datastore) *NameSystem {
+	return &NameSystem{store: store, ds: ds}
+}
+
+// Publish points the name of sk at value with a record whose sequence
+// number follows the highest one known for it, from the local cache or the
+// routing system, expired records included. If only the routing put fails
+// the error wraps ErrRoutingPut.
+func (ns *NameSystem) Publish(ctx context.Context, sk crypto.PrivKey, value path.Path, opts PublishOptions) (*ipns.Record, error) {
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	name := ipns.NameFromPeer(pid)
+	lifetime := opts.Lifetime
+	if lifetime == 0 {
+		lifetime = ipns.DefaultRecordLifetime
+	}
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = ipns.DefaultRecordTTL
+	}
+
+	seq, found, err := ns.lastSequence(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		seq++
+	}
+	rec, err := ipns.NewRecord(sk, value, seq, time.Now().Add(lifetime), ttl)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+	if err := ns.cache(ctx, name, data); err != nil {
+		return nil, err
+	}
+	if err := ns.store.PutValue(ctx, string(name.RoutingKey()), data); err != nil {
+		return rec, fmt.Errorf("%w: %w", ErrRoutingPut, err)
+	}
+	return rec, nil
+}
+
+// ResolveName returns the value of the latest valid record of name. A
+// cached record is used while its TTL lasts, and after that if the routing
+// lookup fails.
+func (ns *NameSystem) ResolveName(ctx context.Context, name ipns.Name) (path.Path, error) {
+	cached, fetched, err := ns.cached(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if ttl, err := cached.TTL(); err == nil && time.Since(fetched) < ttl {
+			return cached.Value()
+		}
+	}
+
+	data, err := ns.store.GetValue(ctx, string(name.RoutingKey()))
+	if err == nil {
+		var rec *ipns.Record
+		if rec, err = ipns.UnmarshalRecord(data); err == nil {
+			err = ipns.ValidateWithName(rec, name)
+		}
+		if err == nil && cached != nil && newer(cached, rec) {
+			// the network lags behind what this node already knows,
+			// e.g. a record it just published
+			return cached.Value()
+		}
+		if err == nil {
+			if err := ns.cache(ctx, name, data); err != nil {
+				return nil, err
+			}
+			return rec.Value()
+		}
+	}
+	if cached != nil {
+		return cached.Value()
+	}
+	if errors.Is(err, corerouting.ErrNotFound) || errors.Is(err, kb.ErrLookupFailure) {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return nil, err
+}
+
+// Resolve follows p through any names it passes until it reaches an
+// immutable /ipfs/ path, keeping the remaining segments of p.
+func (ns *NameSystem) Resolve(ctx context.Context, p path.Path) (path.ImmutablePath, error) {
+	for range maxResolveDepth {
+		if !p.Mutable() {
+			return path.NewImmutablePath(p)
+		}
+		segments := p.Segments()
+		name, err := ipns.NameFromString(segments[1])
+		if err != nil {
+			return path.ImmutablePath{}, err
+		}
+		value, err := ns.ResolveName(ctx, name)
+		if err != nil {
+			return path.ImmutablePath{}, err
+		}
+		if p, err = path.Join(value, segments[2:]...); err != nil {
+			return path.ImmutablePath{}, err
+		}
+	}
+	return path.ImmutablePath{}, fmt.Errorf("namesys: %s: more than %d names to resolve", p, maxResolveDepth)
+}
+
+// lastSequence returns the highest sequence number among the records of
+// name in the cache and in the routing system, and whether there was any. A
+// failed lookup leaves only the cache to go by, as when publishing offline.
+func (ns *NameSystem) lastSequence(ctx context.Context, name ipns.Name) (uint64, bool, error) {
+	var seq uint64
+	found := false
+	consider := func(rec *ipns.Record) error {
+		s, err := rec.Sequence()
+		if err != nil {
+			return err
+		}
+		if !found || s > seq {
+			seq = s
+		}
+		found = true
+		return nil
+	}
+
+	prev, _, err := ns.stored(ctx, name)
+	if err != nil {
+		return 0, false, err
+	}
+	if prev != nil {
+		if err := consider(prev); err != nil {
+			return 0, false, err
+		}
+	}
+	data, err := ns.store.GetValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, false, ctx.Err()
+		}
+		return seq, found, nil
+	}
+	if rec, err := ipns.UnmarshalRecord(data); err == nil && signed(rec, name) {
+		if err := consider(rec); err != nil {
+			return 0, false, err
+		}
+	}
+	return seq, found, nil
+}
+
+// cached returns the cached record of name, if any and not expired, and
+// when it was stored.
+func (ns *NameSystem) cached(ctx context.Context, name ipns.Name) (*ipns.Record, time.Time, error) {
+	rec, fetched, err := ns.stored(ctx, name)
+	if rec == nil || err != nil {
+		return nil, time.Time{}, err
+	}
+	if ipns.ValidateWithName(rec, name) != nil {
+		// expired: as good as missing
+		return nil, time.Time{}, nil
+	}
+	return rec, fetched, nil
+}
+
+// stored returns the cached record of name, expired or not, and when it was
+// stored.
+func (ns *NameSystem) stored(ctx context.Context, name ipns.Name) (*ipns.Record, time.Time, error) {
+	v, err := ns.ds.Get(ctx, CacheBucket, name.RoutingKey())
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(v) < 8 {
+		return nil, time.Time{}, nil
+	}
+	rec, err := ipns.UnmarshalRecord(v[8:])
+	if err != nil || !signed(rec, name) {
+		// corrupt: as good as missing
+		return nil, time.Time{}, nil
+	}
+	return rec, time.Unix(0, int64(binary.BigEndian.Uint64(v))), nil
+}
+
+// signed reports whether rec is a well-formed record of name signed by its
+// key, whether or not it has expired.
+func signed(rec *ipns.Record, name ipns.Name) bool {
+	err := ipns.ValidateWithName(rec, name)
+	return err == nil || errors.Is(err, ipns.ErrExpiredRecord)
+}
+
+// newer reports whether rec has a higher sequence number than old, or the
+// same one and a later end of validity.
+func newer(rec, old *ipns.Record) bool {
+	seq, _ := rec.Sequence()
+	oldSeq, _ := old.Sequence()
+	if seq != oldSeq {
+		return seq > oldSeq
+	}
+	eol, _ := rec.Validity()
+	oldEOL, _ := old.Validity()
+	return eol.After(oldEOL)
+}
+
+// cache stores the serialized record data of name with the current time.
+func (ns *NameSystem) cache(ctx context.Context, name ipns.Name, data []byte) error {
+	v := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	return ns.ds.Put(ctx, CacheBucket, name.RoutingKey(), append(v, data...))
+}
@@ -0,0 +1,218 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	corerouting "github.com/libp2p/go-libp2p/core/routing"
+
+	"p2pfs/internal/datastore"
+)
+
+// mapStore is a ValueStore shared by several name systems, standing in for
+// the DHT.
+type mapStore struct {
+	mu      sync.Mutex
+	records map[string][]byte
+	gets    int
+}
+
+func (m *mapStore) PutValue(ctx context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[key] = value
+	return nil
+}
+
+func (m *mapStore) GetValue(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets++
+	v, ok := m.records[key]
+	if !ok {
+		return nil, corerouting.ErrNotFound
+	}
+	return v, nil
+}
+
+func newKey(t *testing.T) (crypto.PrivKey, ipns.Name) {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk, ipns.NameFromPeer(pid)
+}
+
+func contentPath(data string) path.Path {
+	return path.FromCid(blockformat.NewBlock([]byte(data)).Cid())
+}
+
+func TestPublishResolve(t *testing.T) {
+	ctx := context.Background()
+	store := &mapStore{records: make(map[string][]byte)}
+	publisher := New(store, datastore.NewMapDatastore())
+	resolver := New(store, datastore.NewMapDatastore())
+	sk, name := newKey(t)
+
+	v1 := contentPath("v1")
+	rec, err := publisher.Publish(ctx, sk, v1, PublishOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := rec.Sequence(); seq != 0 {
+		t.Fatalf("first record has sequence %d", seq)
+	}
+	got, err := resolver.ResolveName(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != v1.String() {
+		t.Fatalf("resolved %s, want %s", got, v1)
+	}
+
+	// republishing bumps the sequence; the publisher sees the new value at
+	// once, the resolver keeps its cached record until the TTL runs out
+	v2 := contentPath("v2")
+	rec, err = publisher.Publish(ctx, sk, v2, PublishOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := rec.Sequence(); seq != 1 {
+		t.Fatalf("second record has sequence %d", seq)
+	}
+	if got, _ := publisher.ResolveName(ctx, name); got.String() != v2.String() {
+		t.Fatalf("publisher resolved %s, want %s", got, v2)
+	}
+	gets := store.gets
+	if got, _ := resolver.ResolveName(ctx, name); got.String() != v1.String() {
+		t.Fatalf("resolver resolved %s before its TTL expired, want cached %s", got, v1)
+	}
+	if store.gets != gets {
+		t.Fatal("cached resolution queried the store")
+	}
+
+	// a record with an expired TTL is fetched again
+	if _, err := publisher.Publish(ctx, sk, v2, PublishOptions{TTL: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	fresh := New(store, datastore.NewMapDatastore())
+	fresh.ResolveName(ctx, name)
+	gets = store.gets
+	fresh.ResolveName(ctx, name)
+	if store.gets != gets+1 {
+		t.Fatal("record with expired TTL was served from the cache")
+	}
+}
+
+func TestResolveChainAndErrors(t *testing.T) {
+	ctx := context.Background()
+	store := &mapStore{records: make(map[string][]byte)}
+	ns := New(store, datastore.NewMapDatastore())
+	skA, nameA := newKey(t)
+	skB, nameB := newKey(t)
+
+	target := contentPath("target")
+	if _, err := ns.Publish(ctx, skB, target, PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// A points into B with a sub-path
+	viaB, err := path.Join(nameB.AsPath(), "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Publish(ctx, skA, viaB, PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := path.Join(nameA.AsPath(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ns.Resolve(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := target.String() + "/dir/file"; got.String() != want {
+		t.Fatalf("resolved %s, want %s", got, want)
+	}
+
+	_, unknown := newKey(t)
+	if _, err := ns.ResolveName(ctx, unknown); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unpublished name, got %v", err)
+	}
+
+	// a record signed by another key is rejected
+	forged, err := ipns.NewRecord(skB, contentPath("forged"), 5, time.Now().Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ipns.MarshalRecord(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.records[string(unknown.RoutingKey())] = data
+	if _, err := ns.ResolveName(ctx, unknown); err == nil {
+		t.Fatal("record signed by the wrong key resolved")
+	}
+}
+
+func TestPublishSequenceFromNetwork(t *testing.T) {
+	ctx := context.Background()
+	store := &mapStore{records: make(map[string][]byte)}
+	sk, name := newKey(t)
+
+	// another node with the same key published seq 0 and 1
+	other := New(store, datastore.NewMapDatastore())
+	for _, v := range []string{"a", "b"} {
+		if _, err := other.Publish(ctx, sk, contentPath(v), PublishOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ns := New(store, datastore.NewMapDatastore())
+	rec, err := ns.Publish(ctx, sk, contentPath("c"), PublishOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := rec.Sequence(); seq != 2 {
+		t.Fatalf("record after the network's seq 1 has sequence %d", seq)
+	}
+
+	// an expired record still counts, cached or in the network
+	if _, err := ns.Publish(ctx, sk, contentPath("d"), PublishOptions{Lifetime: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	rec, err = ns.Publish(ctx, sk, contentPath("e"), PublishOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := rec.Sequence(); seq != 4 {
+		t.Fatalf("record after an expired seq 3 has sequence %d", seq)
+	}
+	// the network dropped everything and the cache holds only an expired
+	// record
+	if _, err := ns.Publish(ctx, sk, contentPath("f"), PublishOptions{Lifetime: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	delete(store.records, string(name.RoutingKey()))
+	rec, err = ns.Publish(ctx, sk, contentPath("g"), PublishOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := rec.Sequence(); seq != 6 {
+		t.Fatalf("record after an expired cached seq 5 has sequence %d", seq)
+	}
+}
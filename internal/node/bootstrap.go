@@ -0,0 +1,161 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/config"
+)
+
+// bootstrapProtectTag protects connections to bootstrap peers from the
+// connection manager.
+const bootstrapProtectTag = "bootstrap"
+
+// bootstrapConfig controls the periodic bootstrapper.
+type bootstrapConfig struct {
+	// MinPeerThreshold is the number of connected peers below which the
+	// bootstrapper dials bootstrap peers.
+	MinPeerThreshold int
+	// Period is the time between checks.
+	Period time.Duration
+	// ConnectionTimeout bounds each dial to a bootstrap peer.
+	ConnectionTimeout time.Duration
+	// RefreshTimeout bounds the routing table refresh after new connections.
+	RefreshTimeout time.Duration
+}
+
+var defaultBootstrapConfig = bootstrapConfig{
+	MinPeerThreshold:  4,
+	Period:            30 * time.Second,
+	ConnectionTimeout: 10 * time.Second,
+	RefreshTimeout:    time.Minute,
+}
+
+// startBootstrapper protects the bootstrap peers from connection trimming,
+// then runs a bootstrap round now and every Period until ctx is canceled.
+func (n *Node) startBootstrapper(ctx context.Context, peers []peer.AddrInfo, cfg bootstrapConfig) {
+	if len(peers) == 0 {
+		return
+	}
+	for _, pi := range peers {
+		n.Host.ConnManager().Protect(pi.ID, bootstrapProtectTag)
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(cfg.Period)
+		defer ticker.Stop()
+		for {
+			if err := n.bootstrapRound(ctx, peers, cfg); err != nil && ctx.Err() == nil {
+				log.Printf("bootstrap: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// bootstrapRound connects to bootstrap peers if fewer than MinPeerThreshold
+// peers are connected, then refreshes the DHT routing table.
+func (n *Node) bootstrapRound(ctx context.Context, peers []peer.AddrInfo, cfg bootstrapConfig) error {
+	connected := len(n.Host.Network().Peers())
+	if connected >= cfg.MinPeerThreshold {
+		return nil
+	}
+	var candidates []peer.AddrInfo
+	for _, pi := range peers {
+		if pi.ID != n.Host.ID() && n.Host.Network().Connectedness(pi.ID) != network.Connected {
+			candidates = append(candidates, pi)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if need := cfg.MinPeerThreshold - connected; len(candidates) > need {
+		candidates = candidates[:need]
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var dialed int
+	var lastErr error
+	for _, pi := range candidates {
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, cfg.ConnectionTimeout)
+			defer cancel()
+			err := n.Host.Connect(cctx, pi)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			dialed++
+		}(pi)
+	}
+	wg.Wait()
+	if dialed == 0 {
+		return fmt.Errorf("failed to connect to any of %d bootstrap peers: %w", len(candidates), lastErr)
+	}
+
+	if n.DHT == nil {
+		return nil
+	}
+	rctx, cancel := context.WithTimeout(ctx, cfg.RefreshTimeout)
+	defer cancel()
+	if err := n.DHT.RefreshRoutingTable(rctx); err != nil {
+		return fmt.Errorf("refresh routing table: %w", err)
+	}
+	return nil
+}
+
+// parsePeerAddrs parses configured peer multiaddrs, merging addresses of
+// the same peer. what names the setting in errors.
+func parsePeerAddrs(what string, addrs []string) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	index := make(map[peer.ID]int)
+	for _, s := range addrs {
+		info, err := peer.AddrInfoFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", what, s, err)
+		}
+		if i, ok := index[info.ID]; ok {
+			infos[i].Addrs = append(infos[i].Addrs, info.Addrs...)
+			continue
+		}
+		index[info.ID] = len(infos)
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// publicBootstrapPeers returns the entries of addrs whose peer is one of the
+// public bootstrap peers in config.DefaultBootstrap.
+func publicBootstrapPeers(addrs []string) []string {
+	public := make(map[peer.ID]bool)
+	for _, s := range config.DefaultBootstrap {
+		if info, err := peer.AddrInfoFromString(s); err == nil {
+			public[info.ID] = true
+		}
+	}
+	var out []string
+	for _, s := range addrs {
+		if info, err := peer.AddrInfoFromString(s); err == nil && public[info.ID] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
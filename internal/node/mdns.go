@@ -0,0 +1,57 @@
+package node
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// MDNSServiceName is the mDNS service p2pfs nodes advertise themselves under.
+const MDNSServiceName = "_p2pfs._udp"
+
+// mdnsConnectTimeout bounds the dial to a peer found on the local network.
+const mdnsConnectTimeout = 10 * time.Second
+
+// mdnsNotifee connects to peers found by mDNS and adds them to the DHT
+// routing table and the Bitswap peer set.
+type mdnsNotifee struct {
+	n   *Node
+	ctx context.Context
+}
+
+func (m *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	if pi.ID == m.n.Host.ID() {
+		return
+	}
+	m.n.wg.Add(1)
+	go func() {
+		defer m.n.wg.Done()
+		ctx, cancel := context.WithTimeout(m.ctx, mdnsConnectTimeout)
+		defer cancel()
+		if err := m.n.Host.Connect(ctx, pi); err != nil {
+			if m.ctx.Err() == nil {
+				log.Printf("mdns: connect to %s: %v", pi.ID, err)
+			}
+			return
+		}
+		// the peer may run its DHT in client mode; it is still a bitswap peer
+		if m.n.DHT != nil {
+			m.n.DHT.AddPeer(pi.ID)
+		}
+		m.n.Bitswap.AddPeer(pi.ID)
+	}()
+}
+
+// startMDNS starts advertising the node on the local network and connecting
+// to other p2pfs nodes found there.
+func (n *Node) startMDNS(ctx context.Context) error {
+	svc := mdns.NewMdnsService(n.Host, MDNSServiceName, &mdnsNotifee{n: n, ctx: ctx})
+	if err := svc.Start(); err != nil {
+		return err
+	}
+	n.mdns = svc
+	return nil
+}
@@ -0,0 +1,389 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"p2pfs/internal/bitswap"
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/config"
+	"p2pfs/internal/datastore"
+	"p2pfs/internal/namesys"
+	"p2pfs/internal/p2p"
+	"p2pfs/internal/pin"
+	"p2pfs/internal/pubsub"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+// Config configures New.
+type Config struct {
+	// Repo backs the node. If nil the node keeps all data in memory, uses a
+	// fresh identity and does not bootstrap.
+	Repo *repo.Repo
+	// Passphrase decrypts the repo identity and keystore keys if they are
+	// encrypted.
+	Passphrase []byte
+	// Registerer, if set, receives node metrics.
+	Registerer prometheus.Registerer
+	// EnableMDNS turns on local network discovery even if the repo config
+	// leaves it off.
+	EnableMDNS bool
+}
+
+// Node bundles the storage and networking services of a running p2pfs node.
+type Node struct {
+	Repo       *repo.Repo
+	Datastore  datastore.Datastore
+	Blockstore blockstore.Blockstore
+	Host       corehost.Host
+	DHT        *routing.KademliaDHT
+	Bitswap    *bitswap.Bitswap
+	Pins       *pin.Store
+	// Routing finds and announces providers: the DHT combined with any
+	// configured static providers and delegated routers. With routing type
+	// "delegated" there is no DHT and DHT is nil.
+	Routing routing.ContentRouting
+	// Reprovider re-announces content to the DHT; nil if disabled or the
+	// node runs no DHT.
+	Reprovider *routing.Reprovider
+	// ProvideQueue holds newly imported content until it is announced. It
+	// is only drained by nodes running the DHT.
+	ProvideQueue *routing.ProvideQueue
+	// Names publishes and resolves IPNS names through the DHT, or only
+	// the local cache without one.
+	Names *namesys.NameSystem
+	// PubSub broadcasts signed messages on named topics between peers.
+	PubSub *pubsub.PubSub
+
+	strategy   string // Reprovider.Strategy
+	passphrase []byte // Config.Passphrase, for the keystore
+	// cached is the block cache under Blockstore; its bloom filter build
+	// reads the repo store until stopped.
+	cached *blockstore.CachedBlockstore
+	// policy guards the DAGs listed in Bitswap.ProtectedRoots; Blockstore
+	// stores through it so new blocks of those DAGs are covered.
+	policy *bitswap.RootPolicy
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mdns   mdns.Service
+}
+
+// New starts a node: it opens the stores, creates the libp2p host, DHT,
+// Bitswap engine and pubsub, and starts the bootstrapper that keeps the node
+// connected to its bootstrap peers, the provide queue, the reprovider and,
+// if enabled, mDNS discovery. The caller owns cfg.Repo; Close does not close it.
+func New(ctx context.Context, cfg Config) (*Node, error) {
+	n := &Node{Repo: cfg.Repo}
+	cacheOpts := blockstore.DefaultCacheOptions()
+	cacheOpts.Registerer = cfg.Registerer
+	var hostOpts p2p.Options
+	var bootstrap, staticProviders []peer.AddrInfo
+	var delegated []string
+	routingType := routing.TypeDHT
+	var dhtOpts routing.Options
+	enableMDNS := cfg.EnableMDNS
+	if cfg.Repo != nil {
+		n.Datastore = cfg.Repo.Datastore()
+		n.Blockstore = cfg.Repo.Blockstore()
+		rcfg := cfg.Repo.Config()
+		cc := rcfg.Datastore.BlockCache
+		cacheOpts.Policy = cc.Policy
+		cacheOpts.Size = cc.Size
+		cacheOpts.BloomSize = cc.BloomFilterSize
+		sk, err := cfg.Repo.Identity(cfg.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		n.passphrase = cfg.Passphrase
+		psk, err := cfg.Repo.SwarmKey()
+		if err != nil {
+			return nil, err
+		}
+		if psk != nil {
+			if public := publicBootstrapPeers(rcfg.Bootstrap); len(public) > 0 {
+				return nil, fmt.Errorf("%s is present but Bootstrap lists public peers %s; remove them with 'p2pfs bootstrap rm' to run a private swarm",
+					repo.SwarmKeyFile, strings.Join(public, ", "))
+			}
+		}
+		bootstrap, err = parsePeerAddrs("bootstrap", rcfg.Bootstrap)
+		if err != nil {
+			return nil, err
+		}
+		staticProviders, err = parsePeerAddrs("static provider", rcfg.Routing.StaticProviders)
+		if err != nil {
+			return nil, err
+		}
+		delegated = rcfg.Routing.DelegatedRouters
+		switch rcfg.Routing.Type {
+		case "", routing.TypeDHT:
+		case routing.TypeDelegated:
+			if len(staticProviders) == 0 && len(delegated) == 0 {
+				return nil, fmt.Errorf("routing type %q needs Routing.DelegatedRouters or Routing.StaticProviders", routing.TypeDelegated)
+			}
+			if rcfg.Addresses.Routing != "" {
+				return nil, fmt.Errorf("Addresses.Routing answers from the DHT and needs routing type %q", routing.TypeDHT)
+			}
+			routingType = routing.TypeDelegated
+		default:
+			return nil, fmt.Errorf("unknown routing type %q (want %s or %s)", rcfg.Routing.Type, routing.TypeDHT, routing.TypeDelegated)
+		}
+		dhtOpts.Mode = rcfg.Routing.DHT.Mode
+		dhtOpts.BucketSize = rcfg.Routing.DHT.BucketSize
+		if rcfg.Routing.DHT.PersistProviders {
+			dhtOpts.Datastore = datastore.NewBatching(n.Datastore, routing.DHTBucket)
+		}
+		enableMDNS = enableMDNS || rcfg.Discovery.MDNS.Enabled
+		hostOpts = p2p.Options{
+			ListenAddrs: rcfg.Addresses.Swarm,
+			Announce:    rcfg.Addresses.Announce,
+			NoAnnounce:  rcfg.Addresses.NoAnnounce,
+			Identity:    sk,
+			PSK:         psk,
+			Limits: p2p.ResourceLimits{
+				MaxMemory:         rcfg.Swarm.ResourceMgr.MaxMemory,
+				MaxConnections:    rcfg.Swarm.ResourceMgr.MaxConnections,
+				MaxStreams:        rcfg.Swarm.ResourceMgr.MaxStreams,
+				MaxStreamsPerPeer: rcfg.Swarm.ResourceMgr.MaxStreamsPerPeer,
+			},
+			NAT: p2p.NATOptions{
+				AutoNAT:      rcfg.Swarm.EnableAutoNAT,
+				RelayClient:  rcfg.Swarm.RelayClient.Enabled,
+				StaticRelays: rcfg.Swarm.RelayClient.StaticRelays,
+				RelayService: rcfg.Swarm.RelayService.Enabled,
+				HolePunching: rcfg.Swarm.EnableHolePunching,
+			},
+			Gater: p2p.GaterOptions{
+				AllowedPeers: rcfg.Swarm.Gater.AllowedPeers,
+				DeniedPeers:  rcfg.Swarm.Gater.DeniedPeers,
+				DeniedAddrs:  rcfg.Swarm.Gater.DeniedAddrs,
+			},
+		}
+		if cm := rcfg.Swarm.ConnMgr; cm.HighWater > 0 {
+			hostOpts.ConnMgr = &p2p.ConnMgrOptions{
+				LowWater:    cm.LowWater,
+				HighWater:   cm.HighWater,
+				GracePeriod: time.Duration(cm.GracePeriod),
+			}
+		}
+	} else {
+		n.Datastore = datastore.NewMapDatastore()
+		n.Blockstore = blockstore.NewMapBlockstore()
+	}
+	cached, err := blockstore.NewCachedBlockstore(n.Blockstore, cacheOpts)
+	if err != nil {
+		return nil, err
+	}
+	n.cached = cached
+	n.policy = bitswap.NewRootPolicy(cached)
+	n.Blockstore = n.policy.Blockstore(cached)
+	n.Pins = pin.NewStore(n.Datastore)
+	n.ProvideQueue = routing.NewProvideQueue(n.Datastore)
+
+	n.Host, err = p2p.NewHost(ctx, hostOpts)
+	if err != nil {
+		n.cached.Stop()
+		return nil, err
+	}
+	var routers []routing.ContentRouting
+	var values namesys.ValueStore = routing.NoValueStore{}
+	if routingType == routing.TypeDHT {
+		dhtOpts.Private = hostOpts.PSK != nil
+		n.DHT, err = routing.NewKademliaDHT(ctx, n.Host, dhtOpts)
+		if err != nil {
+			n.Host.Close()
+			n.cached.Stop()
+			return nil, err
+		}
+		if err := n.DHT.Bootstrap(ctx); err != nil {
+			n.closeDHT()
+			n.Host.Close()
+			n.cached.Stop()
+			return nil, err
+		}
+		routers = append(routers, n.DHT)
+		values = n.DHT
+	}
+	n.Names = namesys.New(values, n.Datastore)
+	if len(staticProviders) > 0 {
+		routers = append(routers, routing.NewStaticRouter(staticProviders))
+	}
+	for _, u := range delegated {
+		routers = append(routers, routing.NewHTTPRouter(u, nil))
+	}
+	n.Routing = routers[0]
+	if len(routers) > 1 {
+		n.Routing = routing.NewParallelRouter(routers...)
+	}
+	n.Bitswap = bitswap.NewBitswap(n.Host, n.Routing, n.Blockstore)
+	n.PubSub, err = pubsub.New(n.Host)
+	if err != nil {
+		n.closeDHT()
+		n.Host.Close()
+		n.cached.Stop()
+		return nil, err
+	}
+	if cfg.Repo != nil {
+		if err := n.applyAccessPolicy(ctx, cfg.Repo.Config().Bitswap.ProtectedRoots); err != nil {
+			n.PubSub.Close()
+			n.closeDHT()
+			n.Host.Close()
+			n.cached.Stop()
+			return nil, err
+		}
+		rp := cfg.Repo.Config().Reprovider
+		n.strategy = rp.Strategy
+		if rp.Interval > 0 && n.DHT != nil {
+			keys, err := routing.NewStrategyKeys(rp.Strategy, n.Blockstore, n.Pins.List)
+			if err != nil {
+				n.PubSub.Close()
+				n.closeDHT()
+				n.Host.Close()
+				n.cached.Stop()
+				return nil, err
+			}
+			keys = routing.ExcludeNoProvide(keys, n.Blockstore, n.Datastore)
+			n.Reprovider = routing.NewReprovider(n.Routing, n.Datastore, keys, routing.ReproviderOptions{
+				Interval:     time.Duration(rp.Interval),
+				InitialDelay: reproviderInitialDelay,
+			})
+		}
+	}
+
+	var bctx context.Context
+	bctx, n.cancel = context.WithCancel(context.Background())
+	n.startBootstrapper(bctx, bootstrap, defaultBootstrapConfig)
+	if n.DHT != nil {
+		// without it nothing can announce content; queued entries wait for
+		// a node on this repo that runs the DHT
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.ProvideQueue.Run(bctx, n.Routing, routing.DefaultQueueOptions())
+		}()
+	}
+	if n.Reprovider != nil {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.Reprovider.Run(bctx)
+		}()
+	}
+	if enableMDNS {
+		if err := n.startMDNS(bctx); err != nil {
+			n.Close()
+			return nil, fmt.Errorf("start mdns: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// Key returns the private key called name: the node identity for
+// repo.SelfKey or an empty name, otherwise a key from the repo keystore.
+func (n *Node) Key(name string) (crypto.PrivKey, error) {
+	if name == "" || name == repo.SelfKey {
+		return n.Host.Peerstore().PrivKey(n.Host.ID()), nil
+	}
+	if n.Repo == nil {
+		return nil, fmt.Errorf("%s: %w", name, repo.ErrKeyNotFound)
+	}
+	ks, err := n.Repo.Keystore(n.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ks.Get(name)
+}
+
+// QueueProvide queues the DAG under root to be announced in the background.
+// Which of its blocks are announced follows Reprovider.Strategy. It undoes an
+// earlier SkipProvide of root.
+func (n *Node) QueueProvide(ctx context.Context, root cid.Cid) error {
+	keys, err := routing.ProvideKeys(ctx, n.strategy, n.Blockstore, root)
+	if err != nil {
+		return err
+	}
+	if err := routing.SetNoProvide(ctx, n.Datastore, root, false); err != nil {
+		return err
+	}
+	return n.ProvideQueue.Enqueue(ctx, keys...)
+}
+
+// SkipProvide keeps the DAG under root unannounced: the reprovider leaves its
+// blocks out until the DAG is provided again.
+func (n *Node) SkipProvide(ctx context.Context, root cid.Cid) error {
+	return routing.SetNoProvide(ctx, n.Datastore, root, true)
+}
+
+// reproviderInitialDelay lets the bootstrapper connect the node before the
+// first reprovide run.
+const reproviderInitialDelay = time.Minute
+
+// applyAccessPolicy restricts the configured protected DAGs to their
+// allowed peers.
+func (n *Node) applyAccessPolicy(ctx context.Context, protected []config.ProtectedRoot) error {
+	if len(protected) == 0 {
+		return nil
+	}
+	for _, pr := range protected {
+		root, err := cid.Parse(pr.Cid)
+		if err != nil {
+			return fmt.Errorf("invalid protected root %q: %w", pr.Cid, err)
+		}
+		var peers []peer.ID
+		for _, s := range pr.AllowedPeers {
+			id, err := peer.Decode(s)
+			if err != nil {
+				return fmt.Errorf("invalid allowed peer %q for %s: %w", s, root, err)
+			}
+			peers = append(peers, id)
+		}
+		if err := n.policy.Protect(ctx, root, peers); err != nil {
+			return fmt.Errorf("protect %s: %w", root, err)
+		}
+	}
+	n.Bitswap.SetAccessPolicy(n.policy)
+	return nil
+}
+
+// Protected reports whether c is a block of a DAG listed in
+// Bitswap.ProtectedRoots, which Bitswap serves only to the allowed peers.
+func (n *Node) Protected(c cid.Cid) bool {
+	return n.policy.Protected(c)
+}
+
+// closeDHT closes the DHT, if the node runs one.
+func (n *Node) closeDHT() {
+	if n.DHT != nil {
+		n.DHT.Close()
+	}
+}
+
+// Close stops the node's networking services and the block cache's
+// background work. In-memory stores are closed too; a repo is left open for
+// its owner to close.
+func (n *Node) Close() error {
+	if n.mdns != nil {
+		n.mdns.Close()
+	}
+	n.cancel()
+	n.wg.Wait()
+	n.PubSub.Close()
+	n.closeDHT()
+	err := n.Host.Close()
+	n.cached.Stop()
+	if n.Repo == nil {
+		n.Blockstore.Close()
+		n.Datastore.Close()
+	}
+	return err
+}
@@ -0,0 +1,508 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/bitswap"
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/config"
+	"p2pfs/internal/namesys"
+	"p2pfs/internal/pubsub"
+	"p2pfs/internal/repo"
+	"p2pfs/internal/routing"
+)
+
+const testSwarmKey = "/key/swarm/psk/1.0.0/\n/base16/\n" +
+	"6d2f6e6c7c1a0e3b5f9a4d8c2b7e1f03a9c4d6e8f0b2a4c6e8d0f2b4a6c8e0d2\n"
+
+// newTestRepo creates a repo listening on loopback with no bootstrap peers
+// and reproviding disabled. If swarmKey is non-empty it is written to the
+// repo's swarm.key.
+func newTestRepo(t *testing.T, swarmKey string, bootstrap []string) *repo.Repo {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo")
+	cfg := config.Default()
+	cfg.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/0"}
+	cfg.Addresses.NoAnnounce = nil
+	cfg.Bootstrap = bootstrap
+	cfg.Reprovider.Interval = 0
+	if err := repo.Init(path, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if swarmKey != "" {
+		if err := os.WriteFile(filepath.Join(path, repo.SwarmKeyFile), []byte(swarmKey), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := repo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func startTestNode(t *testing.T, r *repo.Repo) *Node {
+	t.Helper()
+	n, err := New(context.Background(), Config{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestPrivateSwarm(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, testSwarmKey, nil))
+	b := startTestNode(t, newTestRepo(t, testSwarmKey, nil))
+	public := startTestNode(t, newTestRepo(t, "", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatalf("peers sharing the swarm key failed to connect: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := public.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err == nil {
+		t.Fatal("peer without the swarm key connected to the private swarm")
+	}
+}
+
+func TestPrivateSwarmRejectsPublicBootstrap(t *testing.T) {
+	r := newTestRepo(t, testSwarmKey, config.DefaultBootstrap[:1])
+	_, err := New(context.Background(), Config{Repo: r})
+	if err == nil || !strings.Contains(err.Error(), "public peers") {
+		t.Fatalf("expected public bootstrap error, got %v", err)
+	}
+}
+
+func TestBootstrapper(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	var addrs []string
+	for _, addr := range a.Host.Addrs() {
+		addrs = append(addrs, addr.String()+"/p2p/"+a.Host.ID().String())
+	}
+	b := startTestNode(t, newTestRepo(t, "", addrs))
+
+	deadline := time.Now().Add(10 * time.Second)
+	for b.Host.Network().Connectedness(a.Host.ID()) != network.Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("node did not connect to its bootstrap peer")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// a dropped connection is restored by the next bootstrap round
+	b.Host.Network().ClosePeer(a.Host.ID())
+	cfg := defaultBootstrapConfig
+	infos, err := parsePeerAddrs("bootstrap", addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// the routing table refresh fails: loopback-only peers run the DHT in
+	// client mode, so there is nobody to query
+	b.bootstrapRound(ctx, infos, cfg)
+	if b.Host.Network().Connectedness(a.Host.ID()) != network.Connected {
+		t.Fatal("bootstrap round did not reconnect")
+	}
+}
+
+func TestMDNSPeerFound(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	b := startTestNode(t, newTestRepo(t, "", nil))
+
+	notifee := &mdnsNotifee{n: b, ctx: context.Background()}
+	notifee.HandlePeerFound(peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()})
+	notifee.HandlePeerFound(peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()})
+	for deadline := time.Now().Add(10 * time.Second); len(b.Bitswap.Peers()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if b.Host.Network().Connectedness(a.Host.ID()) != network.Connected {
+		t.Fatal("discovered peer not connected")
+	}
+	if peers := b.Bitswap.Peers(); len(peers) != 1 || peers[0] != a.Host.ID() {
+		t.Fatalf("bitswap peer set = %v, want [%s]", peers, a.Host.ID())
+	}
+
+	// a peer that went away is not asked for blocks anymore
+	if err := b.Host.Network().ClosePeer(a.Host.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); len(b.Bitswap.Peers()) != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peers := b.Bitswap.Peers(); len(peers) != 0 {
+		t.Fatalf("bitswap peer set after disconnect = %v", peers)
+	}
+}
+
+func TestConnManagerTags(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	addr := a.Host.Addrs()[0].String() + "/p2p/" + a.Host.ID().String()
+	b := startTestNode(t, newTestRepo(t, "", []string{addr}))
+
+	if !b.Host.ConnManager().IsProtected(a.Host.ID(), bootstrapProtectTag) {
+		t.Fatal("bootstrap peer is not protected")
+	}
+
+	ctx := context.Background()
+	blk := blockformat.NewBlock([]byte("tagged block"))
+	if err := a.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	b.Bitswap.AddPeer(a.Host.ID())
+	if _, err := b.Bitswap.GetBlock(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		n    *Node
+		peer peer.ID
+	}{{b, a.Host.ID()}, {a, b.Host.ID()}} {
+		info := tc.n.Host.ConnManager().GetTagInfo(tc.peer)
+		if info == nil || info.Tags["bitswap-useful"] == 0 {
+			t.Fatalf("peer %s not tagged after exchanging a block: %+v", tc.peer, info)
+		}
+		if tc.n.Host.ConnManager().IsProtected(tc.peer, "bitswap") {
+			t.Fatalf("peer %s still protected after the exchange finished", tc.peer)
+		}
+	}
+}
+
+func TestProtectedRoots(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	b := startTestNode(t, newTestRepo(t, "", nil))
+	c := startTestNode(t, newTestRepo(t, "", nil))
+
+	ctx := context.Background()
+	child := merkledag.NewRawNode([]byte("protected leaf"))
+	root := merkledag.NodeWithData([]byte("protected root"))
+	if err := root.AddNodeLink("leaf", child); err != nil {
+		t.Fatal(err)
+	}
+	public := blockformat.NewBlock([]byte("public block"))
+	for _, blk := range []blockformat.Block{child, root, public} {
+		if err := a.Blockstore.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a protected DAG that only arrives after the node started
+	lateChild := merkledag.NewRawNode([]byte("late leaf"))
+	lateRoot := merkledag.NodeWithData([]byte("late root"))
+	if err := lateRoot.AddNodeLink("leaf", lateChild); err != nil {
+		t.Fatal(err)
+	}
+	err := a.applyAccessPolicy(ctx, []config.ProtectedRoot{
+		{Cid: root.Cid().String(), AllowedPeers: []string{b.Host.ID().String()}},
+		{Cid: lateRoot.Cid().String(), AllowedPeers: []string{b.Host.ID().String()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leaves first, as the importer writes them
+	for _, blk := range []blockformat.Block{lateChild, lateRoot} {
+		if err := a.Blockstore.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, n := range []*Node{b, c} {
+		if err := n.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+			t.Fatal(err)
+		}
+		n.Bitswap.AddPeer(a.Host.ID())
+	}
+	if _, err := b.Bitswap.GetBlock(ctx, child.Cid()); err != nil {
+		t.Fatalf("allowed peer: %v", err)
+	}
+	for _, id := range []cid.Cid{child.Cid(), lateRoot.Cid(), lateChild.Cid()} {
+		if _, err := c.Bitswap.GetBlock(ctx, id); !errors.Is(err, blockstore.ErrNotFound) {
+			t.Fatalf("%s: expected not found for a peer outside the allowlist, got %v", id, err)
+		}
+	}
+	if _, err := b.Bitswap.GetBlock(ctx, lateChild.Cid()); err != nil {
+		t.Fatalf("allowed peer, block stored after start: %v", err)
+	}
+	if _, err := c.Bitswap.GetBlock(ctx, public.Cid()); err != nil {
+		t.Fatalf("unprotected block: %v", err)
+	}
+}
+
+// stallingRouter streams its providers and then keeps the search open until
+// the caller gives up, like a slow DHT query.
+type stallingRouter struct{ providers []peer.AddrInfo }
+
+func (r stallingRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return routing.ErrNotSupported
+}
+
+func (r stallingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for _, pi := range r.providers {
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return out
+}
+
+func TestGetBlockFetchesWhileSearching(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	b := startTestNode(t, newTestRepo(t, "", nil))
+	blk := blockformat.NewBlock([]byte("found mid-search"))
+	if err := a.Blockstore.Put(context.Background(), blk); err != nil {
+		t.Fatal(err)
+	}
+
+	router := stallingRouter{providers: []peer.AddrInfo{{ID: a.Host.ID(), Addrs: a.Host.Addrs()}}}
+	engine := bitswap.NewBitswap(b.Host, router, b.Blockstore)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := engine.GetBlock(ctx, blk.Cid()); err != nil {
+		t.Fatalf("block not fetched before the search finished: %v", err)
+	}
+}
+
+func TestDHTRoutingTable(t *testing.T) {
+	ra := newTestRepo(t, "", nil)
+	ra.Config().Routing.DHT.Mode = routing.ModeServer
+	rb := newTestRepo(t, "", nil)
+	rb.Config().Routing.DHT.Mode = routing.ModeServer
+	a := startTestNode(t, ra)
+	b := startTestNode(t, rb)
+	if a.DHT.Mode() != routing.ModeServer {
+		t.Fatalf("dht mode %s, want server", a.DHT.Mode())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		for _, bucket := range a.DHT.RoutingTable() {
+			for _, p := range bucket.Peers {
+				if p.ID == b.Host.ID() {
+					return
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("connected server peer never entered the routing table")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestDHTPersistsProviders(t *testing.T) {
+	r := newTestRepo(t, "", nil)
+	n, err := New(context.Background(), Config{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := blockformat.NewBlock([]byte("persisted provider")).Cid()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// fails for want of DHT peers, but still records the local provider
+	n.DHT.Provide(ctx, c, true)
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	n = startTestNode(t, r)
+	providers := routing.FindProviders(ctx, n.DHT, c, 1)
+	if len(providers) != 1 || providers[0].ID != n.Host.ID() {
+		t.Fatalf("providers after restart: %v", providers)
+	}
+}
+
+func TestDelegatedRoutingType(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	blk := blockformat.NewBlock([]byte("found without a dht"))
+	if err := a.Blockstore.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRepo(t, "", nil)
+	r.Config().Routing.Type = routing.TypeDelegated
+	if _, err := New(ctx, Config{Repo: r}); err == nil {
+		t.Fatal("expected an error without delegated routers or static providers")
+	}
+	r.Config().Routing.StaticProviders = []string{a.Host.Addrs()[0].String() + "/p2p/" + a.Host.ID().String()}
+	b := startTestNode(t, r)
+	if b.DHT != nil {
+		t.Fatal("delegated routing started a DHT")
+	}
+	if _, err := b.Bitswap.GetBlock(ctx, blk.Cid()); err != nil {
+		t.Fatalf("block not fetched from the static provider: %v", err)
+	}
+
+	// names are signed and kept locally, never put to a DHT
+	self, err := b.Key(repo.SelfKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := path.FromCid(blk.Cid())
+	if _, err := b.Names.Publish(ctx, self, value, namesys.PublishOptions{}); !errors.Is(err, routing.ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported from the routing put, got %v", err)
+	}
+	got, err := b.Names.ResolveName(ctx, ipns.NameFromPeer(b.Host.ID()))
+	if err != nil || got.String() != value.String() {
+		t.Fatalf("resolved %v, %v; want %s", got, err, value)
+	}
+}
+
+func TestNameResolvesThroughDHT(t *testing.T) {
+	ra := newTestRepo(t, "", nil)
+	ra.Config().Routing.DHT.Mode = routing.ModeServer
+	rb := newTestRepo(t, "", nil)
+	rb.Config().Routing.DHT.Mode = routing.ModeServer
+	a := startTestNode(t, ra)
+	b := startTestNode(t, rb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	for a.DHT.RoutingTableSize() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("peers never entered each other's routing tables")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	value := path.FromCid(blockformat.NewBlock([]byte("named content")).Cid())
+	sk := a.Host.Peerstore().PrivKey(a.Host.ID())
+	if _, err := a.Names.Publish(ctx, sk, value, namesys.PublishOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Names.ResolveName(ctx, ipns.NameFromPeer(a.Host.ID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != value.String() {
+		t.Fatalf("resolved %s, want %s", got, value)
+	}
+}
+
+func TestPublishWithNamedKey(t *testing.T) {
+	r := newTestRepo(t, "", nil)
+	ks, err := r.Keystore(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := repo.GenerateKey(repo.KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("dataset", sk); err != nil {
+		t.Fatal(err)
+	}
+	n := startTestNode(t, r)
+
+	self, err := n.Key(repo.SelfKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := peer.IDFromPrivateKey(self); id != n.Host.ID() {
+		t.Fatalf("self key belongs to %s, want the node identity %s", id, n.Host.ID())
+	}
+	if _, err := n.Key("missing"); !errors.Is(err, repo.ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	key, err := n.Key("dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	value := path.FromCid(blockformat.NewBlock([]byte("dataset v1")).Cid())
+	// the node has no DHT peers; the record is validated and kept locally
+	if _, err := n.Names.Publish(ctx, key, value, namesys.PublishOptions{}); err != nil && !errors.Is(err, namesys.ErrRoutingPut) {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := n.Names.ResolveName(ctx, ipns.NameFromPeer(pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != value.String() {
+		t.Fatalf("resolved %s, want %s", got, value)
+	}
+}
+
+func TestPubSubBetweenNodes(t *testing.T) {
+	a := startTestNode(t, newTestRepo(t, "", nil))
+	b := startTestNode(t, newTestRepo(t, "", nil))
+	sub, err := b.PubSub.Subscribe("datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := b.Host.Connect(ctx, peer.AddrInfo{ID: a.Host.ID(), Addrs: a.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	for len(a.PubSub.ListPeers("datasets")) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("b's subscription never reached a")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// messages published while the nodes are still setting up GossipSub
+	// with each other can be lost, so publish until one arrives
+	var m *pubsub.Message
+	for m == nil {
+		if err := a.PubSub.Publish("datasets", []byte("new dataset available")); err != nil {
+			t.Fatal(err)
+		}
+		next, cancelNext := context.WithTimeout(ctx, 200*time.Millisecond)
+		m, err = sub.Next(next)
+		cancelNext()
+		if err != nil && ctx.Err() != nil {
+			t.Fatal(err)
+		}
+	}
+	// signed with the repo identity
+	if m.From != a.Host.ID() || m.ReceivedFrom != a.Host.ID() || string(m.Data) != "new dataset available" {
+		t.Fatalf("received %+v", m)
+	}
+}
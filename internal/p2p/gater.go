@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// GaterOptions restricts which peers and addresses the host talks to.
+type GaterOptions struct {
+	// AllowedPeers, if non-empty, is the only set of peers the host
+	// connects to.
+	AllowedPeers []string
+	// DeniedPeers are never connected to.
+	DeniedPeers []string
+	// DeniedAddrs are CIDR filters such as /ip4/10.0.0.0/ipcidr/8; peers
+	// are neither dialed nor accepted on matching addresses.
+	DeniedAddrs []string
+}
+
+// gater is a connection gater enforcing GaterOptions.
+type gater struct {
+	allowed map[peer.ID]bool
+	denied  map[peer.ID]bool
+	filters *ma.Filters
+}
+
+func newGater(o GaterOptions) (*gater, error) {
+	g := &gater{denied: make(map[peer.ID]bool), filters: ma.NewFilters()}
+	if len(o.AllowedPeers) > 0 {
+		g.allowed = make(map[peer.ID]bool)
+		for _, s := range o.AllowedPeers {
+			id, err := peer.Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed peer %q: %w", s, err)
+			}
+			g.allowed[id] = true
+		}
+	}
+	for _, s := range o.DeniedPeers {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied peer %q: %w", s, err)
+		}
+		g.denied[id] = true
+	}
+	for _, s := range o.DeniedAddrs {
+		ipnet, ok, err := parseCIDRFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid denied address %q: want /ip4/<ip>/ipcidr/<bits> or /ip6/<ip>/ipcidr/<bits>", s)
+		}
+		g.filters.AddFilter(*ipnet, ma.ActionDeny)
+	}
+	return g, nil
+}
+
+func (g *gater) peerAllowed(p peer.ID) bool {
+	if g.denied[p] {
+		return false
+	}
+	return g.allowed == nil || g.allowed[p]
+}
+
+func (g *gater) InterceptPeerDial(p peer.ID) bool {
+	return g.peerAllowed(p)
+}
+
+func (g *gater) InterceptAddrDial(_ peer.ID, addr ma.Multiaddr) bool {
+	return !g.filters.AddrBlocked(addr)
+}
+
+func (g *gater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return !g.filters.AddrBlocked(addrs.RemoteMultiaddr())
+}
+
+func (g *gater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.peerAllowed(p)
+}
+
+func (g *gater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
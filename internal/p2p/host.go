@@ -3,19 +3,297 @@ package p2p
 import (
 	"context"
 	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
-// NewHost initializes a libp2p host listening on the given TCP port.
-func NewHost(ctx context.Context, listenPort int) (corehost.Host, error) {
-	addr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", listenPort)
-	h, err := libp2p.New(
-		libp2p.ListenAddrStrings(addr),
-	)
+// AgentVersion is announced to peers via the identify protocol.
+const AgentVersion = "p2pfs/0.1.0"
+
+// DefaultListenAddrs are used when Options.ListenAddrs is empty: TCP, QUIC
+// and WebSocket on both IP versions, on ports picked by the OS.
+var DefaultListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/0",
+	"/ip6/::/tcp/0",
+	"/ip4/0.0.0.0/udp/0/quic-v1",
+	"/ip6/::/udp/0/quic-v1",
+	"/ip4/0.0.0.0/tcp/0/ws",
+	"/ip6/::/tcp/0/ws",
+}
+
+// Options configures NewHost.
+type Options struct {
+	// ListenAddrs are the multiaddrs to listen on. If empty the host listens
+	// on DefaultListenAddrs.
+	ListenAddrs []string
+	// Announce, if set, replaces the listen addresses advertised to peers.
+	Announce []string
+	// NoAnnounce removes matching addresses from those advertised. Entries
+	// are either multiaddrs or CIDR filters such as /ip4/10.0.0.0/ipcidr/8.
+	NoAnnounce []string
+	// Identity is the host's private key. If nil a fresh key is generated,
+	// so the Peer ID changes on every start.
+	Identity crypto.PrivKey
+	// PSK, if set, makes the host part of a private swarm: it only connects
+	// to peers with the same key. QUIC cannot carry a PSK, so QUIC listen
+	// addresses are skipped.
+	PSK pnet.PSK
+	// ConnMgr, if set, trims connections between the watermarks.
+	ConnMgr *ConnMgrOptions
+	// Limits caps the resources the host may use. Zero fields keep the
+	// libp2p defaults scaled to the machine.
+	Limits ResourceLimits
+	// NAT configures NAT traversal.
+	NAT NATOptions
+	// Gater restricts the peers and addresses the host connects to.
+	Gater GaterOptions
+}
+
+// NATOptions configures how a host behind NAT stays reachable.
+type NATOptions struct {
+	// AutoNAT runs the AutoNAT v1 and v2 services, which let peers check
+	// whether they are publicly reachable. The host always probes its own
+	// reachability.
+	AutoNAT bool
+	// RelayClient reserves slots on circuit relay v2 relays and advertises
+	// /p2p-circuit addresses while the host is not publicly reachable.
+	// Relays are StaticRelays if set, otherwise connected peers.
+	RelayClient  bool
+	StaticRelays []string
+	// RelayService relays traffic for other peers while this host is
+	// publicly reachable.
+	RelayService bool
+	// HolePunching upgrades relayed connections to direct ones with DCUtR.
+	HolePunching bool
+}
+
+// ConnMgrOptions configures the connection manager.
+type ConnMgrOptions struct {
+	LowWater    int
+	HighWater   int
+	GracePeriod time.Duration
+}
+
+// ResourceLimits are system-wide resource manager limits.
+type ResourceLimits struct {
+	MaxMemory         int64
+	MaxConnections    int
+	MaxStreams        int
+	MaxStreamsPerPeer int
+}
+
+// NewHost initializes a libp2p host listening on the configured addresses
+// over TCP, QUIC and WebSocket.
+func NewHost(ctx context.Context, opts Options) (corehost.Host, error) {
+	listen := opts.ListenAddrs
+	if len(listen) == 0 {
+		listen = DefaultListenAddrs
+	}
+	addrsFactory, err := newAddrsFactory(opts.Announce, opts.NoAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	libp2pOpts := []libp2p.Option{
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(websocket.New),
+		libp2p.AddrsFactory(addrsFactory),
+		libp2p.UserAgent(AgentVersion),
+	}
+	if opts.PSK != nil {
+		listen = withoutQUIC(listen)
+		libp2pOpts = append(libp2pOpts, libp2p.PrivateNetwork(opts.PSK))
+	} else {
+		libp2pOpts = append(libp2pOpts, libp2p.Transport(quic.NewTransport))
+	}
+	libp2pOpts = append(libp2pOpts, libp2p.ListenAddrStrings(listen...))
+	if opts.ConnMgr != nil {
+		cm, err := connmgr.NewConnManager(opts.ConnMgr.LowWater, opts.ConnMgr.HighWater,
+			connmgr.WithGracePeriod(opts.ConnMgr.GracePeriod))
+		if err != nil {
+			return nil, fmt.Errorf("connection manager: %w", err)
+		}
+		libp2pOpts = append(libp2pOpts, libp2p.ConnectionManager(cm))
+	}
+	rm, err := newResourceManager(opts.Limits)
+	if err != nil {
+		return nil, fmt.Errorf("resource manager: %w", err)
+	}
+	libp2pOpts = append(libp2pOpts, libp2p.ResourceManager(rm))
+	if opts.Identity != nil {
+		libp2pOpts = append(libp2pOpts, libp2p.Identity(opts.Identity))
+	}
+	g, err := newGater(opts.Gater)
 	if err != nil {
 		return nil, err
 	}
+	libp2pOpts = append(libp2pOpts, libp2p.ConnectionGater(g))
+	var hostRef atomic.Pointer[corehost.Host]
+	natOpts, err := natOptions(opts.NAT, &hostRef)
+	if err != nil {
+		return nil, err
+	}
+	libp2pOpts = append(libp2pOpts, natOpts...)
+	h, err := libp2p.New(libp2pOpts...)
+	if err != nil {
+		return nil, err
+	}
+	hostRef.Store(&h)
 	return h, nil
 }
+
+// natOptions translates o into libp2p options. Without static relays the
+// relay client picks relays among the peers connected to the host in ref,
+// which is set once the host exists.
+func natOptions(o NATOptions, ref *atomic.Pointer[corehost.Host]) ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if o.AutoNAT {
+		opts = append(opts, libp2p.EnableNATService(), libp2p.EnableAutoNATv2())
+	}
+	if o.RelayService {
+		opts = append(opts, libp2p.EnableRelayService())
+	}
+	if o.HolePunching {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+	if !o.RelayClient {
+		return opts, nil
+	}
+	if len(o.StaticRelays) > 0 {
+		var relays []peer.AddrInfo
+		for _, s := range o.StaticRelays {
+			info, err := peer.AddrInfoFromString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid static relay %q: %w", s, err)
+			}
+			relays = append(relays, *info)
+		}
+		return append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays)), nil
+	}
+	peerSource := func(ctx context.Context, num int) <-chan peer.AddrInfo {
+		out := make(chan peer.AddrInfo, num)
+		defer close(out)
+		hp := ref.Load()
+		if hp == nil {
+			return out
+		}
+		h := *hp
+		for _, p := range h.Network().Peers() {
+			if len(out) == num {
+				break
+			}
+			out <- peer.AddrInfo{ID: p, Addrs: h.Peerstore().Addrs(p)}
+		}
+		return out
+	}
+	return append(opts, libp2p.EnableAutoRelayWithPeerSource(peerSource)), nil
+}
+
+// newResourceManager returns a resource manager with the libp2p default
+// limits, scaled to the machine and overridden by the non-zero fields of l.
+func newResourceManager(l ResourceLimits) (network.ResourceManager, error) {
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	var system, peerDefault rcmgr.ResourceLimits
+	if l.MaxMemory > 0 {
+		system.Memory = rcmgr.LimitVal64(l.MaxMemory)
+	}
+	if l.MaxConnections > 0 {
+		system.Conns = rcmgr.LimitVal(l.MaxConnections)
+	}
+	if l.MaxStreams > 0 {
+		system.Streams = rcmgr.LimitVal(l.MaxStreams)
+	}
+	if l.MaxStreamsPerPeer > 0 {
+		peerDefault.Streams = rcmgr.LimitVal(l.MaxStreamsPerPeer)
+	}
+	limits := rcmgr.PartialLimitConfig{System: system, PeerDefault: peerDefault}.Build(scaling.AutoScale())
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits))
+}
+
+// withoutQUIC drops the QUIC addresses from addrs.
+func withoutQUIC(addrs []string) []string {
+	var out []string
+	for _, s := range addrs {
+		if addr, err := ma.NewMultiaddr(s); err == nil {
+			if _, err := addr.ValueForProtocol(ma.P_QUIC_V1); err == nil {
+				continue
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// newAddrsFactory returns the function that turns the host's listen
+// addresses into the addresses it advertises.
+func newAddrsFactory(announce, noAnnounce []string) (func([]ma.Multiaddr) []ma.Multiaddr, error) {
+	var announced []ma.Multiaddr
+	for _, s := range announce {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announce address %q: %w", s, err)
+		}
+		announced = append(announced, addr)
+	}
+	filters := ma.NewFilters()
+	excluded := make(map[string]bool)
+	for _, s := range noAnnounce {
+		if ipnet, ok, err := parseCIDRFilter(s); err != nil {
+			return nil, err
+		} else if ok {
+			filters.AddFilter(*ipnet, ma.ActionDeny)
+			continue
+		}
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid no-announce address %q: %w", s, err)
+		}
+		excluded[addr.String()] = true
+	}
+
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if len(announced) > 0 {
+			addrs = announced
+		}
+		out := make([]ma.Multiaddr, 0, len(addrs))
+		for _, addr := range addrs {
+			if excluded[addr.String()] || filters.AddrBlocked(addr) {
+				continue
+			}
+			out = append(out, addr)
+		}
+		return out
+	}, nil
+}
+
+// parseCIDRFilter parses a filter of the form /ip4/<ip>/ipcidr/<bits> or
+// /ip6/<ip>/ipcidr/<bits>. ok is false if s is not of that form.
+func parseCIDRFilter(s string) (ipnet *net.IPNet, ok bool, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 5 || parts[0] != "" || parts[3] != "ipcidr" {
+		return nil, false, nil
+	}
+	if parts[1] != "ip4" && parts[1] != "ip6" {
+		return nil, false, fmt.Errorf("invalid address filter %q", s)
+	}
+	_, ipnet, err = net.ParseCIDR(parts[2] + "/" + parts[4])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid address filter %q: %w", s, err)
+	}
+	return ipnet, true, nil
+}
@@ -0,0 +1,203 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"p2pfs/internal/config"
+)
+
+func TestAddrsFactory(t *testing.T) {
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		ma.StringCast("/ip4/203.0.113.7/tcp/4001"),
+		ma.StringCast("/ip4/203.0.113.7/udp/4001/quic-v1"),
+		ma.StringCast("/ip6/::1/tcp/4001"),
+	}
+	factory, err := newAddrsFactory(nil, []string{
+		"/ip4/127.0.0.0/ipcidr/8",
+		"/ip4/10.0.0.0/ipcidr/8",
+		"/ip6/::1/ipcidr/128",
+		"/ip4/203.0.113.7/udp/4001/quic-v1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := factory(addrs)
+	if len(got) != 1 || got[0].String() != "/ip4/203.0.113.7/tcp/4001" {
+		t.Fatalf("filtered addrs = %v", got)
+	}
+
+	factory, err = newAddrsFactory([]string{"/dns4/example.com/tcp/4001"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = factory(addrs)
+	if len(got) != 1 || got[0].String() != "/dns4/example.com/tcp/4001" {
+		t.Fatalf("announced addrs = %v", got)
+	}
+
+	if _, err := newAddrsFactory(nil, []string{"/ip4/10.0.0.0/ipcidr/99"}); err == nil {
+		t.Fatal("expected error for invalid filter")
+	}
+}
+
+func TestDefaultNoAnnounce(t *testing.T) {
+	factory, err := newAddrsFactory(nil, config.Default().Addresses.NoAnnounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := factory([]ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		ma.StringCast("/ip4/172.20.0.5/tcp/4001"),
+		ma.StringCast("/ip4/192.168.1.10/udp/4001/quic-v1"),
+		ma.StringCast("/ip4/100.64.3.4/tcp/4001"),
+		ma.StringCast("/ip6/fd12:3456::1/tcp/4001"),
+		ma.StringCast("/ip6/fe80::1/tcp/4001"),
+		ma.StringCast("/ip4/203.0.113.7/tcp/4001"),
+		ma.StringCast("/ip6/2001:db8::1/tcp/4001"),
+	})
+	if len(got) != 2 || got[0].String() != "/ip4/203.0.113.7/tcp/4001" || got[1].String() != "/ip6/2001:db8::1/tcp/4001" {
+		t.Fatalf("default filters left %v", got)
+	}
+}
+
+// TestNewHostTransports connects hosts over each transport and opens a
+// stream, so a listener that fails on accept does not go unnoticed.
+func TestNewHostTransports(t *testing.T) {
+	const proto = "/p2pfs/test/echo"
+	for _, addr := range []string{
+		"/ip4/127.0.0.1/tcp/0",
+		"/ip4/127.0.0.1/udp/0/quic-v1",
+		"/ip4/127.0.0.1/tcp/0/ws",
+	} {
+		t.Run(addr, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			listener, err := NewHost(ctx, Options{ListenAddrs: []string{addr}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			listener.SetStreamHandler(proto, func(s network.Stream) {
+				defer s.Close()
+				io.Copy(s, s)
+			})
+			dialer, err := NewHost(ctx, Options{ListenAddrs: []string{addr}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dialer.Close()
+
+			if err := dialer.Connect(ctx, peer.AddrInfo{ID: listener.ID(), Addrs: listener.Addrs()}); err != nil {
+				t.Fatal(err)
+			}
+			conns := dialer.Network().ConnsToPeer(listener.ID())
+			if len(conns) == 0 {
+				t.Fatal("no connection after connect")
+			}
+			want := ma.StringCast(addr).Protocols()
+			got := conns[0].RemoteMultiaddr().Protocols()
+			if got[len(got)-1].Code != want[len(want)-1].Code {
+				t.Fatalf("connected over %s, want %s", conns[0].RemoteMultiaddr(), addr)
+			}
+			s, err := dialer.NewStream(ctx, listener.ID(), proto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			s.CloseWrite()
+			echo, err := io.ReadAll(s)
+			if err != nil || string(echo) != "ping" {
+				t.Fatalf("echo = %q, %v", echo, err)
+			}
+		})
+	}
+}
+
+func TestNATOptions(t *testing.T) {
+	_, err := NewHost(context.Background(), Options{NAT: NATOptions{
+		RelayClient:  true,
+		StaticRelays: []string{"/ip4/127.0.0.1/tcp/4001"},
+	}})
+	if err == nil {
+		t.Fatal("expected error for static relay without a peer ID")
+	}
+
+	h, err := NewHost(context.Background(), Options{
+		ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"},
+		NAT: NATOptions{
+			AutoNAT:      true,
+			RelayClient:  true,
+			RelayService: true,
+			HolePunching: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	info, err := HostInfo(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a loopback-only host cannot learn its reachability
+	if info.Reachability != "Unknown" {
+		t.Fatalf("reachability = %q, want Unknown", info.Reachability)
+	}
+}
+
+func TestGater(t *testing.T) {
+	ctx := context.Background()
+	newHost := func(g GaterOptions) corehost.Host {
+		t.Helper()
+		h, err := NewHost(ctx, Options{ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0"}, Gater: g})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	server := newHost(GaterOptions{})
+	friend := newHost(GaterOptions{})
+	stranger := newHost(GaterOptions{})
+	gated := newHost(GaterOptions{
+		AllowedPeers: []string{server.ID().String(), friend.ID().String()},
+		DeniedPeers:  []string{friend.ID().String()},
+	})
+	info := func(h corehost.Host) peer.AddrInfo { return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()} }
+
+	if err := gated.Connect(ctx, info(server)); err != nil {
+		t.Fatalf("allowed peer: %v", err)
+	}
+	if err := gated.Connect(ctx, info(friend)); err == nil {
+		t.Fatal("dialed a denied peer")
+	}
+	if err := stranger.Connect(ctx, info(gated)); err == nil {
+		// the handshake may complete on the dialer's side before the gated
+		// host closes the connection
+		time.Sleep(100 * time.Millisecond)
+		if gated.Network().Connectedness(stranger.ID()) == network.Connected {
+			t.Fatal("accepted a peer that is not allowed")
+		}
+	}
+
+	local := newHost(GaterOptions{DeniedAddrs: []string{"/ip4/127.0.0.0/ipcidr/8"}})
+	if err := local.Connect(ctx, info(server)); err == nil {
+		t.Fatal("dialed a denied address")
+	}
+	if _, err := newGater(GaterOptions{DeniedAddrs: []string{"/ip4/127.0.0.1/tcp/1"}}); err == nil {
+		t.Fatal("expected error for a denied address without /ipcidr/")
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat"
+)
+
+// Info describes a host as reported by `p2pfs id`.
+type Info struct {
+	ID           string
+	PublicKey    string
+	Addresses    []string
+	AgentVersion string
+	// Reachability is Public, Private or Unknown as determined by AutoNAT.
+	Reachability string
+}
+
+// HostInfo collects the identity information of a running host.
+func HostInfo(h corehost.Host) (Info, error) {
+	pub, err := crypto.MarshalPublicKey(h.Peerstore().PubKey(h.ID()))
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		ID:           h.ID().String(),
+		PublicKey:    base64.StdEncoding.EncodeToString(pub),
+		Addresses:    []string{},
+		AgentVersion: AgentVersion,
+		Reachability: Reachability(h).String(),
+	}
+	for _, addr := range h.Addrs() {
+		info.Addresses = append(info.Addresses, fmt.Sprintf("%s/p2p/%s", addr, h.ID()))
+	}
+	return info, nil
+}
+
+// Reachability returns the host's reachability as determined by AutoNAT, or
+// ReachabilityUnknown if it has not been determined yet.
+func Reachability(h corehost.Host) network.Reachability {
+	if nh, ok := h.(interface{ GetAutoNat() autonat.AutoNAT }); ok {
+		if an := nh.GetAutoNat(); an != nil {
+			return an.Status()
+		}
+	}
+	return network.ReachabilityUnknown
+}
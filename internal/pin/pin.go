@@ -0,0 +1,56 @@
+// Package pin records the DAG roots a node keeps and announces.
+package pin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"p2pfs/internal/datastore"
+)
+
+// Bucket maps pinned CIDs to the time they were pinned.
+const Bucket = "pins"
+
+// Store is the set of pinned roots, persisted in a datastore.
+type Store struct {
+	ds datastore.Datastore
+}
+
+// NewStore returns the pin set kept in ds.
+func NewStore(ds datastore.Datastore) *Store {
+	return &Store{ds: ds}
+}
+
+// Add pins c. Pinning an already pinned CID keeps the original pin time.
+func (s *Store) Add(ctx context.Context, c cid.Cid) error {
+	if ok, err := s.Has(ctx, c); err != nil || ok {
+		return err
+	}
+	return s.ds.Put(ctx, Bucket, c.Bytes(), []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// Has reports whether c is pinned.
+func (s *Store) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, err := s.ds.Get(ctx, Bucket, c.Bytes())
+	if errors.Is(err, datastore.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the pinned CIDs.
+func (s *Store) List(ctx context.Context) ([]cid.Cid, error) {
+	var pins []cid.Cid
+	err := s.ds.ForEach(ctx, Bucket, func(key, _ []byte) error {
+		c, err := cid.Cast(key)
+		if err != nil {
+			return err
+		}
+		pins = append(pins, c)
+		return nil
+	})
+	return pins, err
+}
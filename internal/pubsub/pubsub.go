@@ -0,0 +1,249 @@
+// Package pubsub broadcasts messages on named topics between the peers of a
+// libp2p host over GossipSub.
+//
+// Peers keep a mesh of subscribers per topic and gossip about the messages
+// they have seen, so a message reaches every subscriber linked by a chain of
+// subscribed peers. Every message is signed with the key of the peer that
+// published it and checked by every peer it passes (the StrictSign policy);
+// messages without a valid signature are dropped.
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	gossip "github.com/libp2p/go-libp2p-pubsub"
+	gossippb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxMessageSize bounds the data of a message.
+const MaxMessageSize = 1 << 20
+
+// maxRPCSize bounds an RPC read from a peer: one message of MaxMessageSize
+// with room for its envelope, such as an embedded RSA key.
+const maxRPCSize = MaxMessageSize + 64<<10
+
+var (
+	// ErrClosed is returned after Close.
+	ErrClosed = errors.New("pubsub: closed")
+	// ErrSubscriptionCancelled is returned by Next after Cancel.
+	ErrSubscriptionCancelled = errors.New("pubsub: subscription cancelled")
+	// ErrInvalidSignature is returned by Verify for messages not signed by
+	// their sender.
+	ErrInvalidSignature = errors.New("pubsub: invalid message signature")
+	// ErrEmptyTopic is returned for an empty topic name.
+	ErrEmptyTopic = errors.New("pubsub: empty topic")
+)
+
+// Message is a message published on a topic.
+type Message struct {
+	From  peer.ID `json:"from"`
+	Topic string  `json:"topic"`
+	Data  []byte  `json:"data"`
+	// Seqno numbers the messages of a sender; with From it identifies the
+	// message.
+	Seqno uint64 `json:"seqno"`
+	// Key is the marshalled public key of From, set only if it cannot be
+	// extracted from the peer ID, as for RSA keys.
+	Key       []byte `json:"key,omitempty"`
+	Signature []byte `json:"signature"`
+	// ReceivedFrom is the peer that passed the message to this node, or
+	// this node for messages it published.
+	ReceivedFrom peer.ID `json:"-"`
+}
+
+// fromGossip converts a message delivered by GossipSub.
+func fromGossip(m *gossip.Message) *Message {
+	var seqno uint64
+	if s := m.GetSeqno(); len(s) == 8 {
+		seqno = binary.BigEndian.Uint64(s)
+	}
+	return &Message{
+		From:         m.GetFrom(),
+		Topic:        m.GetTopic(),
+		Data:         m.GetData(),
+		Seqno:        seqno,
+		Key:          m.GetKey(),
+		Signature:    m.GetSignature(),
+		ReceivedFrom: m.ReceivedFrom,
+	}
+}
+
+// Verify checks that m is signed by the key of m.From, as GossipSub signs
+// messages, so a client can check a message it got from a daemon.
+func (m *Message) Verify() error {
+	var pk crypto.PubKey
+	var err error
+	if len(m.Key) > 0 {
+		if pk, err = crypto.UnmarshalPublicKey(m.Key); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+		if !m.From.MatchesPublicKey(pk) {
+			return fmt.Errorf("%w: key does not belong to %s", ErrInvalidSignature, m.From)
+		}
+	} else if pk, err = m.From.ExtractPublicKey(); err != nil {
+		return fmt.Errorf("%w: no key for %s: %w", ErrInvalidSignature, m.From, err)
+	}
+	topic := m.Topic
+	signed, err := (&gossippb.Message{
+		From:  []byte(m.From),
+		Data:  m.Data,
+		Seqno: binary.BigEndian.AppendUint64(nil, m.Seqno),
+		Topic: &topic,
+	}).Marshal()
+	if err != nil {
+		return err
+	}
+	if ok, err := pk.Verify(append([]byte(gossip.SignPrefix), signed...), m.Signature); err != nil || !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// PubSub publishes and subscribes to topics on a host, signing messages
+// with the host's identity key.
+type PubSub struct {
+	gs     *gossip.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// topics holds the topics joined to publish or subscribe; GossipSub
+	// allows one handle per topic.
+	topics map[string]*gossip.Topic
+}
+
+// New starts GossipSub on host with strict message signing.
+func New(host corehost.Host) (*PubSub, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gs, err := gossip.NewGossipSub(ctx, host,
+		gossip.WithMessageSignaturePolicy(gossip.StrictSign),
+		gossip.WithMaxMessageSize(maxRPCSize),
+	)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &PubSub{gs: gs, ctx: ctx, cancel: cancel, topics: make(map[string]*gossip.Topic)}, nil
+}
+
+// Close stops GossipSub and cancels all subscriptions. It does not close
+// the host.
+func (ps *PubSub) Close() error {
+	ps.cancel()
+	return nil
+}
+
+// topic returns the handle of name, joining it first if needed.
+func (ps *PubSub) topic(name string) (*gossip.Topic, error) {
+	if name == "" {
+		return nil, ErrEmptyTopic
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	if t, ok := ps.topics[name]; ok {
+		return t, nil
+	}
+	t, err := ps.gs.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	ps.topics[name] = t
+	return t, nil
+}
+
+// Publish signs data and sends it to the subscribers of topic, including
+// this node's own.
+func (ps *PubSub) Publish(topic string, data []byte) error {
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("pubsub: message of %d bytes exceeds %d", len(data), MaxMessageSize)
+	}
+	t, err := ps.topic(topic)
+	if err != nil {
+		return err
+	}
+	if err := t.Publish(ps.ctx, data); err != nil {
+		if ps.ctx.Err() != nil {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscribe returns a subscription to topic. The first subscription to a
+// topic announces it to the connected peers.
+func (ps *PubSub) Subscribe(topic string) (*Subscription, error) {
+	t, err := ps.topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := t.Subscribe()
+	if err != nil {
+		if ps.ctx.Err() != nil {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ps.ctx)
+	return &Subscription{sub: sub, ctx: ctx, cancel: cancel}, nil
+}
+
+// Topics returns the topics this node subscribes to, in order.
+func (ps *PubSub) Topics() []string {
+	topics := ps.gs.GetTopics()
+	sort.Strings(topics)
+	return topics
+}
+
+// ListPeers returns the connected peers that subscribe to topic, or all
+// connected peers speaking pubsub if topic is empty.
+func (ps *PubSub) ListPeers(topic string) []peer.ID {
+	return ps.gs.ListPeers(topic)
+}
+
+// Subscription receives the messages published on a topic.
+type Subscription struct {
+	sub    *gossip.Subscription
+	ctx    context.Context // done once cancelled or the PubSub is closed
+	cancel context.CancelFunc
+}
+
+// Topic returns the subscribed topic.
+func (s *Subscription) Topic() string {
+	return s.sub.Topic()
+}
+
+// Next returns the next message. It fails with ErrSubscriptionCancelled
+// once the subscription is cancelled or the PubSub closed.
+func (s *Subscription) Next(ctx context.Context) (*Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+	m, err := s.sub.Next(ctx)
+	if s.ctx.Err() != nil || errors.Is(err, gossip.ErrSubscriptionCancelled) {
+		return nil, ErrSubscriptionCancelled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromGossip(m), nil
+}
+
+// Cancel ends the subscription. Cancelling the last subscription to a
+// topic tells the connected peers this node left it.
+func (s *Subscription) Cancel() {
+	s.cancel()
+	s.sub.Cancel()
+}
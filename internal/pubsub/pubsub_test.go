@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	corehost "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func newTestPubSub(t *testing.T) (corehost.Host, *PubSub) {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	ps, err := New(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return h, ps
+}
+
+func connect(t *testing.T, a, b corehost.Host) {
+	t.Helper()
+	if err := a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitPeers waits until ps sees want subscribed to topic.
+func waitPeers(t *testing.T, ps *PubSub, topic string, want ...peer.ID) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := map[peer.ID]bool{}
+		for _, p := range ps.ListPeers(topic) {
+			got[p] = true
+		}
+		ok := true
+		for _, p := range want {
+			ok = ok && got[p]
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peers of %q = %v, want %v", topic, ps.ListPeers(topic), want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// settle publishes on topic from ps until every subscription has received
+// a message, and drains them. Messages published while peers are still
+// setting up GossipSub with each other can be lost.
+func settle(t *testing.T, ps *PubSub, topic string, subs ...*Subscription) {
+	t.Helper()
+	pending := append([]*Subscription(nil), subs...)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(pending) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions to %q received nothing", len(pending), topic)
+		}
+		if err := ps.Publish(topic, []byte("settle")); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		var left []*Subscription
+		for _, sub := range pending {
+			if _, err := sub.Next(ctx); err != nil {
+				left = append(left, sub)
+			}
+		}
+		cancel()
+		pending = left
+	}
+	// drop the settle messages that arrived late
+	for _, sub := range subs {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			_, err := sub.Next(ctx)
+			cancel()
+			if err != nil {
+				break
+			}
+		}
+	}
+}
+
+func TestPublishThroughRelay(t *testing.T) {
+	ha, a := newTestPubSub(t)
+	hb, b := newTestPubSub(t)
+	hc, c := newTestPubSub(t)
+	// a and c only reach each other through b
+	connect(t, ha, hb)
+	connect(t, hc, hb)
+
+	subA, err := a.Subscribe("datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, err := b.Subscribe("datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subC, err := c.Subscribe("datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, b, "datasets", ha.ID(), hc.ID())
+	waitPeers(t, a, "datasets", hb.ID())
+	waitPeers(t, c, "datasets", hb.ID())
+	settle(t, a, "datasets", subA, subB, subC)
+
+	if err := a.Publish("datasets", []byte("new dataset available")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for name, sub := range map[string]*Subscription{"a": subA, "b": subB, "c": subC} {
+		m, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if m.From != ha.ID() || string(m.Data) != "new dataset available" || m.Topic != "datasets" {
+			t.Fatalf("%s received %+v", name, m)
+		}
+		if err := m.Verify(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		tampered := *m
+		tampered.Data = []byte("forged dataset")
+		if err := tampered.Verify(); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: tampered data verified: %v", name, err)
+		}
+		tampered = *m
+		tampered.From = hc.ID()
+		if err := tampered.Verify(); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: message with wrong sender verified: %v", name, err)
+		}
+	}
+	// every node delivers a message once, however many paths it takes
+	short, cancelShort := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelShort()
+	if m, err := subC.Next(short); err == nil {
+		t.Fatalf("c received a duplicate: %+v", m)
+	}
+
+	if got := a.Topics(); len(got) != 1 || got[0] != "datasets" {
+		t.Fatalf("topics = %v", got)
+	}
+	subA.Cancel()
+	if got := a.Topics(); len(got) != 0 {
+		t.Fatalf("topics after cancel = %v", got)
+	}
+	if _, err := subA.Next(ctx); !errors.Is(err, ErrSubscriptionCancelled) {
+		t.Fatalf("next after cancel: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(b.ListPeers("datasets")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("b still lists %v after a unsubscribed", b.ListPeers("datasets"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRSASignedMessages(t *testing.T) {
+	sk, _, err := crypto.GenerateRSAKeyPair(2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(libp2p.Identity(sk), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	ps, err := New(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	sub, err := ps.Subscribe("datasets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("datasets", []byte("from an RSA node")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RSA public keys do not fit in the peer ID and travel with the message
+	if len(m.Key) == 0 {
+		t.Fatal("RSA message without embedded key")
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.Publish("datasets", make([]byte, MaxMessageSize+1)); err == nil {
+		t.Fatal("oversized message published")
+	}
+	ps.Close()
+	if _, err := sub.Next(ctx); !errors.Is(err, ErrSubscriptionCancelled) {
+		t.Fatalf("next after close: %v", err)
+	}
+	if err := ps.Publish("datasets", []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("publish after close: %v", err)
+	}
+}
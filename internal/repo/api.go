@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// APIFile records the address of the daemon currently serving the repo.
+const APIFile = "api"
+
+// APIAddr returns the API address recorded by a running daemon for the repo
+// at path. The error wraps fs.ErrNotExist if no daemon has recorded one.
+func APIAddr(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(path, APIFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteAPIAddr records addr as the API address of the repo at path.
+func WriteAPIAddr(path, addr string) error {
+	return os.WriteFile(filepath.Join(path, APIFile), []byte(addr+"\n"), 0600)
+}
+
+// RemoveAPIAddr removes the API address record of the repo at path.
+func RemoveAPIAddr(path string) error {
+	err := os.Remove(filepath.Join(path, APIFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+// IdentityFile holds the node's private key inside the repo.
+const IdentityFile = "identity"
+
+// EnvPassphrase names the environment variable holding the passphrase used to
+// encrypt the identity and keystore keys when they are written and decrypt
+// them when loading.
+const EnvPassphrase = "P2PFS_PASSPHRASE"
+
+// encryptedKeyMagic prefixes key files encrypted with a passphrase.
+var encryptedKeyMagic = []byte("p2pfs-encrypted-key/v1\n")
+
+const (
+	saltSize = 16
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrPassphraseRequired is returned when loading an encrypted key without a
+// passphrase.
+var ErrPassphraseRequired = errors.New("repo: key is encrypted, passphrase required")
+
+// writeIdentity stores sk in the repo, encrypted if passphrase is non-empty.
+func writeIdentity(path string, sk crypto.PrivKey, passphrase []byte) error {
+	data, err := marshalKey(sk, passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(path, IdentityFile), data, 0600)
+}
+
+// Identity loads the node's private key. passphrase is only used when the
+// key was encrypted at init.
+func (r *Repo) Identity(passphrase []byte) (crypto.PrivKey, error) {
+	return ReadIdentity(r.path, passphrase)
+}
+
+// ReadIdentity loads the private key of the repo at path without opening
+// the repo, e.g. while a daemon holds it.
+func ReadIdentity(path string, passphrase []byte) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(filepath.Join(path, IdentityFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("repo %s has no identity key", path)
+		}
+		return nil, err
+	}
+	return unmarshalKey(data, passphrase)
+}
+
+// marshalKey encodes sk for a key file, encrypted if passphrase is
+// non-empty.
+func marshalKey(sk crypto.PrivKey, passphrase []byte) ([]byte, error) {
+	data, err := crypto.MarshalPrivateKey(sk)
+	if err != nil || len(passphrase) == 0 {
+		return data, err
+	}
+	return encryptKey(data, passphrase)
+}
+
+// unmarshalKey decodes a key file written by marshalKey. passphrase is only
+// used if the file is encrypted.
+func unmarshalKey(data, passphrase []byte) (crypto.PrivKey, error) {
+	if bytes.HasPrefix(data, encryptedKeyMagic) {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("%w (set %s)", ErrPassphraseRequired, EnvPassphrase)
+		}
+		var err error
+		if data, err = decryptKey(data, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+// encryptKey seals data with AES-GCM under a key derived from passphrase.
+// The output is magic || salt || nonce || ciphertext.
+func encryptKey(data, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := keyCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedKeyMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, encryptedKeyMagic), nil
+}
+
+// decryptKey reverses encryptKey.
+func decryptKey(data, passphrase []byte) ([]byte, error) {
+	data = data[len(encryptedKeyMagic):]
+	if len(data) < saltSize {
+		return nil, errors.New("repo: truncated key file")
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+	gcm, err := keyCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("repo: truncated key file")
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, data, encryptedKeyMagic)
+	if err != nil {
+		return nil, errors.New("repo: wrong passphrase or corrupt key file")
+	}
+	return plain, nil
+}
+
+func keyCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// KeystoreDir holds the named private keys of a repo, one file per key.
+const KeystoreDir = "keystore"
+
+// SelfKey is the reserved key name referring to the node identity.
+const SelfKey = "self"
+
+// Key types accepted by GenerateKey.
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+)
+
+// DefaultRSAKeySize is the size of generated RSA keys when none is given.
+const DefaultRSAKeySize = 2048
+
+var (
+	// ErrKeyNotFound is returned for names not in the keystore.
+	ErrKeyNotFound = errors.New("keystore: key not found")
+	// ErrKeyExists is returned when a key name is already taken.
+	ErrKeyExists = errors.New("keystore: key already exists")
+)
+
+// Keystore stores named private keys as files readable only by the owner.
+// It works on the repo directory directly, so keys can be managed while a
+// daemon holds the repo. With a passphrase, keys are written encrypted like
+// the identity; keys stored without one still load.
+type Keystore struct {
+	dir        string
+	passphrase []byte
+}
+
+// OpenKeystore returns the keystore of the repo at path, creating its
+// directory if needed. passphrase encrypts the keys written and decrypts
+// the encrypted ones read; it may be empty.
+func OpenKeystore(path string, passphrase []byte) (*Keystore, error) {
+	dir := filepath.Join(path, KeystoreDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir, passphrase: passphrase}, nil
+}
+
+// Keystore returns the repo's keystore, using passphrase as OpenKeystore
+// does.
+func (r *Repo) Keystore(passphrase []byte) (*Keystore, error) {
+	return OpenKeystore(r.path, passphrase)
+}
+
+// GenerateKey returns a new private key of the given type. bits only
+// applies to RSA keys; 0 means DefaultRSAKeySize.
+func GenerateKey(typ string, bits int) (crypto.PrivKey, error) {
+	switch strings.ToLower(typ) {
+	case KeyTypeEd25519, "":
+		sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		return sk, err
+	case KeyTypeRSA:
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		sk, _, err := crypto.GenerateRSAKeyPair(bits, rand.Reader)
+		return sk, err
+	default:
+		return nil, fmt.Errorf("unknown key type %q (want %s or %s)", typ, KeyTypeEd25519, KeyTypeRSA)
+	}
+}
+
+// validateKeyName rejects names that are reserved or unsafe as file names.
+func validateKeyName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("keystore: empty key name")
+	case name == SelfKey:
+		return fmt.Errorf("keystore: %q is reserved for the node identity", SelfKey)
+	case strings.HasPrefix(name, "."), strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("keystore: invalid key name %q", name)
+	}
+	return nil
+}
+
+// Put stores sk under name, encrypted if the keystore has a passphrase. It
+// fails with ErrKeyExists if the name is taken.
+func (ks *Keystore) Put(name string, sk crypto.PrivKey) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+	data, err := marshalKey(sk, ks.passphrase)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(ks.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s: %w", name, ErrKeyExists)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
+
+// Get returns the key stored under name. An encrypted key needs the
+// keystore's passphrase; without one the error wraps ErrPassphraseRequired.
+func (ks *Keystore) Get(name string) (crypto.PrivKey, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(ks.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrKeyNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sk, err := unmarshalKey(data, ks.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return sk, nil
+}
+
+// List returns the names of the stored keys in order.
+func (ks *Keystore) List() ([]string, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && validateKeyName(e.Name()) == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Rename moves the key stored under oldName to newName. It fails with
+// ErrKeyExists if newName is taken, unless force is set.
+func (ks *Keystore) Rename(oldName, newName string, force bool) error {
+	if err := validateKeyName(oldName); err != nil {
+		return err
+	}
+	if err := validateKeyName(newName); err != nil {
+		return err
+	}
+	oldPath, newPath := filepath.Join(ks.dir, oldName), filepath.Join(ks.dir, newName)
+	if _, err := os.Stat(oldPath); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", oldName, ErrKeyNotFound)
+	}
+	if !force {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("%s: %w", newName, ErrKeyExists)
+		}
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Delete removes the key stored under name.
+func (ks *Keystore) Delete(name string) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(ks.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrKeyNotFound)
+	}
+	return err
+}
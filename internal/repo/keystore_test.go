@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestKeystore(t *testing.T) {
+	path := t.TempDir()
+	ks, err := OpenKeystore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := GenerateKey(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa, err := GenerateKey(KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("dataset-a", ed); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("dataset-b", rsa); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("dataset-a", rsa); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	for _, name := range []string{"", SelfKey, "../escape", ".hidden"} {
+		if err := ks.Put(name, ed); err == nil {
+			t.Fatalf("key name %q was accepted", name)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(path, KeystoreDir, "dataset-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("key file has mode %o, want 600", perm)
+	}
+
+	got, err := ks.Get("dataset-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(rsa) {
+		t.Fatal("stored RSA key differs from the original")
+	}
+
+	if err := ks.Rename("dataset-a", "dataset-b", false); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists renaming onto a taken name, got %v", err)
+	}
+	if err := ks.Rename("dataset-a", "renamed", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Rename("dataset-a", "other", false); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dataset-b", "renamed"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("List = %v, want %v", names, want)
+	}
+
+	if err := ks.Delete("renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("renamed"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound after delete, got %v", err)
+	}
+	if err := ks.Delete("renamed"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestKeystoreEncrypted(t *testing.T) {
+	path := t.TempDir()
+	plain, err := OpenKeystore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := GenerateKey(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Put("old", old); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := OpenKeystore(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := GenerateKey(KeyTypeEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("secret", sk); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(path, KeystoreDir, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := sk.Raw(); bytes.Contains(data, raw) {
+		t.Fatal("key stored in the clear")
+	}
+
+	if _, err := plain.Get("secret"); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("expected ErrPassphraseRequired, got %v", err)
+	}
+	wrong, err := OpenKeystore(path, []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Get("secret"); err == nil {
+		t.Fatal("expected error for wrong passphrase")
+	}
+	for name, want := range map[string]crypto.PrivKey{"secret": sk, "old": old} {
+		got, err := ks.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(want) {
+			t.Fatalf("%s: loaded key differs from the stored one", name)
+		}
+	}
+}
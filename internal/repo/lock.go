@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LockFile holds the PID of the process that has the repo open. The repo is
+// held by an advisory lock on this file, not by its existence, so the
+// operating system releases it when the holder exits.
+const LockFile = "repo.lock"
+
+// ErrLocked is returned when the repo is already open in another process.
+var ErrLocked = errors.New("repo: locked")
+
+// errWouldBlock is returned by lockFile when another open file holds the lock.
+var errWouldBlock = errors.New("lock held elsewhere")
+
+// lock takes the repo lock at path. The lock file is kept open and locked
+// until the returned function is called; calling it more than once is a
+// no-op. The file itself is never removed, so two processes always contend
+// for the same lock.
+func lock(path string) (func() error, error) {
+	name := filepath.Join(path, LockFile)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if !errors.Is(err, errWouldBlock) {
+			return nil, err
+		}
+		if pid := readLockPID(name); pid > 0 {
+			return nil, fmt.Errorf("repo in use by PID %d: %w", pid, ErrLocked)
+		}
+		return nil, fmt.Errorf("repo in use: %w", ErrLocked)
+	}
+	// overwrite whatever PID an exited holder left behind
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return nil, err
+	}
+	var once sync.Once
+	return func() (err error) {
+		once.Do(func() {
+			// clear the PID while still holding the lock so it never
+			// overwrites the PID of the next holder
+			f.Truncate(0)
+			err = unlockFile(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		})
+		return err
+	}, nil
+}
+
+// readLockPID returns the PID recorded in the lock file name, or 0 if it
+// cannot be read or does not hold a PID.
+func readLockPID(name string) int {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package repo
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is where the locked byte range starts. Windows locks are
+// mandatory, so the range sits past the PID to keep it readable by the
+// processes that fail to take the lock.
+const lockOffset = 1 << 32
+
+// lockFile takes an exclusive lock on a byte range of f without waiting.
+func lockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockOffset >> 32}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errWouldBlock
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockOffset >> 32}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
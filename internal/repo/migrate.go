@@ -0,0 +1,172 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"p2pfs/internal/datastore"
+)
+
+// VersionFile records the layout version of the repo.
+const VersionFile = "version"
+
+// CurrentVersion is the repo layout version written by this build. Repos
+// without a version file predate versioning and are treated as version 1.
+const CurrentVersion = 2
+
+// SharedBucket maps the names of shared files to their CIDs.
+const SharedBucket = "shared"
+
+// migration upgrades the datastore layout from version from to from+1.
+type migration struct {
+	from        int
+	description string
+	run         func(ctx context.Context, ds datastore.Datastore) error
+}
+
+var migrations = []migration{
+	{1, "move shared file index from metadata/shared_meta into the shared bucket", migrateSharedMeta},
+}
+
+// Version returns the layout version of the repo at path.
+func Version(path string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(path, VersionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid repo version file: %w", err)
+	}
+	return v, nil
+}
+
+func writeVersion(path string, v int) error {
+	return os.WriteFile(filepath.Join(path, VersionFile), []byte(strconv.Itoa(v)+"\n"), 0600)
+}
+
+// MigrateResult describes a completed migration.
+type MigrateResult struct {
+	From, To int
+	// Backup is the copy of the datastore taken before migrating, or empty
+	// if nothing needed to be migrated.
+	Backup string
+}
+
+// Migrate upgrades the repo at path to CurrentVersion. Open does this
+// automatically; Migrate lets it be done without starting a node.
+func Migrate(path string) (MigrateResult, error) {
+	if !IsInitialized(path) {
+		return MigrateResult{}, fmt.Errorf("%s: %w (run 'p2pfs init')", path, ErrNotInitialized)
+	}
+	unlock, err := lock(path)
+	if err != nil {
+		return MigrateResult{}, err
+	}
+	defer unlock()
+	return migrate(path)
+}
+
+// migrate runs the pending migrations for the repo at path, backing up the
+// datastore first. The caller holds the repo lock.
+func migrate(path string) (MigrateResult, error) {
+	from, err := Version(path)
+	if err != nil {
+		return MigrateResult{}, err
+	}
+	res := MigrateResult{From: from, To: from}
+	if from > CurrentVersion {
+		return res, fmt.Errorf("repo version %d is newer than supported version %d", from, CurrentVersion)
+	}
+	if from == CurrentVersion {
+		return res, nil
+	}
+
+	dbPath := filepath.Join(path, DatastoreFile)
+	res.Backup = fmt.Sprintf("%s.v%d-%s.bak", dbPath, from, time.Now().UTC().Format("20060102T150405Z"))
+	if err := copyFile(dbPath, res.Backup); errors.Is(err, os.ErrNotExist) {
+		res.Backup = ""
+	} else if err != nil {
+		return res, fmt.Errorf("back up datastore: %w", err)
+	}
+	ds, err := datastore.NewBboltDatastore(dbPath, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return res, fmt.Errorf("open datastore: %w", err)
+	}
+	defer ds.Close()
+
+	ctx := context.Background()
+	for _, m := range migrations {
+		if m.from != res.To {
+			continue
+		}
+		if err := m.run(ctx, ds); err != nil {
+			return res, fmt.Errorf("migrate repo from version %d (%s): %w (backup at %s)", m.from, m.description, err, res.Backup)
+		}
+		res.To = m.from + 1
+		if err := writeVersion(path, res.To); err != nil {
+			return res, err
+		}
+	}
+	if res.To != CurrentVersion {
+		return res, fmt.Errorf("no migration from repo version %d", res.To)
+	}
+	return res, nil
+}
+
+// migrateSharedMeta moves the JSON shared files index stored under
+// metadata/shared_meta into one key per file in SharedBucket.
+func migrateSharedMeta(ctx context.Context, ds datastore.Datastore) error {
+	const metadataBucket, sharedMetaKey = "metadata", "shared_meta"
+	data, err := ds.Get(ctx, metadataBucket, []byte(sharedMetaKey))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	shared := make(map[string]string)
+	if err := json.Unmarshal(data, &shared); err != nil {
+		return fmt.Errorf("decode shared_meta: %w", err)
+	}
+	for name, id := range shared {
+		if err := ds.Put(ctx, SharedBucket, []byte(name), []byte(id)); err != nil {
+			return err
+		}
+	}
+	return ds.Delete(ctx, metadataBucket, []byte(sharedMetaKey))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,193 @@
+package repo
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	bbolt "go.etcd.io/bbolt"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/config"
+	"p2pfs/internal/datastore"
+)
+
+// EnvPath names the environment variable that overrides the default repo path.
+const EnvPath = "P2PFS_PATH"
+
+// Names of the files and directories inside a repo.
+const (
+	DefaultDirName = ".p2pfs"
+	ConfigFile     = "config"
+	DatastoreFile  = "datastore.db"
+	BlocksDir      = "blocks"
+)
+
+// ErrNotInitialized is returned when opening a directory without a repo.
+var ErrNotInitialized = errors.New("repo: not initialized")
+
+// Repo is an opened p2pfs repository: its config, metadata datastore and
+// blockstore.
+type Repo struct {
+	path   string
+	cfg    *config.Config
+	ds     datastore.Datastore
+	bs     blockstore.Blockstore
+	unlock func() error
+}
+
+// DefaultPath returns $P2PFS_PATH if set, otherwise ~/.p2pfs.
+func DefaultPath() (string, error) {
+	if p := os.Getenv(EnvPath); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine repo path, set %s: %w", EnvPath, err)
+	}
+	return filepath.Join(home, DefaultDirName), nil
+}
+
+// IsInitialized reports whether path holds a repo.
+func IsInitialized(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ConfigFile))
+	return err == nil
+}
+
+// Init creates a new repo at path using cfg and generates an Ed25519 node
+// identity, encrypted with passphrase if it is non-empty. It fails if a repo
+// already exists.
+func Init(path string, cfg *config.Config, passphrase []byte) error {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return err
+	}
+	return InitWithIdentity(path, cfg, sk, passphrase)
+}
+
+// InitWithIdentity is Init with sk as the node identity, e.g. a key
+// exported from another repo's keystore.
+func InitWithIdentity(path string, cfg *config.Config, sk crypto.PrivKey, passphrase []byte) error {
+	if IsInitialized(path) {
+		return fmt.Errorf("repo already exists at %s", path)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	if err := writeIdentity(path, sk, passphrase); err != nil {
+		return err
+	}
+	cfg.Identity.PeerID = id.String()
+	if err := writeVersion(path, CurrentVersion); err != nil {
+		return err
+	}
+	if err := cfg.Save(filepath.Join(path, ConfigFile)); err != nil {
+		return err
+	}
+	// create the stores so a fresh repo opens without further setup
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// Open opens the repo at path, taking the repo lock and migrating an older
+// layout to CurrentVersion. It returns ErrNotInitialized if there is no repo
+// and an error wrapping ErrLocked if another process has it open.
+func Open(path string) (r *Repo, err error) {
+	cfg, err := config.Load(filepath.Join(path, ConfigFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w (run 'p2pfs init')", path, ErrNotInitialized)
+		}
+		return nil, err
+	}
+	unlock, err := lock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			unlock()
+		}
+	}()
+	if _, err := migrate(path); err != nil {
+		return nil, err
+	}
+	// fail instead of blocking forever when another process holds the db
+	ds, err := datastore.NewBboltDatastore(filepath.Join(path, DatastoreFile), 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open datastore: %w", err)
+	}
+	bs, err := openBlockstore(path, cfg, ds)
+	if err != nil {
+		ds.Close()
+		return nil, err
+	}
+	return &Repo{path: path, cfg: cfg, ds: ds, bs: bs, unlock: unlock}, nil
+}
+
+// openBlockstore opens the block backend selected in cfg.
+func openBlockstore(path string, cfg *config.Config, ds datastore.Datastore) (blockstore.Blockstore, error) {
+	switch cfg.Datastore.Blockstore {
+	case "bbolt", "":
+		bs := blockstore.NewBboltBlockstore(ds)
+		bs.HashOnRead(cfg.Datastore.HashOnRead)
+		return bs, nil
+	case "flatfs":
+		bs, err := blockstore.NewFlatfsBlockstore(filepath.Join(path, BlocksDir), cfg.Datastore.SyncWrites)
+		if err != nil {
+			return nil, err
+		}
+		bs.HashOnRead(cfg.Datastore.HashOnRead)
+		return bs, nil
+	default:
+		return nil, fmt.Errorf("unknown blockstore %q (want bbolt or flatfs)", cfg.Datastore.Blockstore)
+	}
+}
+
+// Path returns the repo directory.
+func (r *Repo) Path() string { return r.path }
+
+// Config returns the loaded config.
+func (r *Repo) Config() *config.Config { return r.cfg }
+
+// Datastore returns the metadata datastore.
+func (r *Repo) Datastore() datastore.Datastore { return r.ds }
+
+// Blockstore returns the blockstore.
+func (r *Repo) Blockstore() blockstore.Blockstore { return r.bs }
+
+// SetConfig replaces the config and persists it.
+func (r *Repo) SetConfig(cfg *config.Config) error {
+	if err := cfg.Save(filepath.Join(r.path, ConfigFile)); err != nil {
+		return err
+	}
+	r.cfg = cfg
+	return nil
+}
+
+// Close closes the blockstore and datastore and releases the repo lock.
+func (r *Repo) Close() error {
+	bsErr := r.bs.Close()
+	dsErr := r.ds.Close()
+	lockErr := r.unlock()
+	if dsErr != nil {
+		return dsErr
+	}
+	if bsErr != nil {
+		return bsErr
+	}
+	return lockErr
+}
@@ -0,0 +1,297 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/config"
+	"p2pfs/internal/datastore"
+)
+
+func TestOpenUninitialized(t *testing.T) {
+	if _, err := Open(t.TempDir()); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+}
+
+func TestInitAndOpen(t *testing.T) {
+	for _, kind := range []string{"bbolt", "flatfs"} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "repo")
+			cfg := config.Default()
+			cfg.Datastore.Blockstore = kind
+			if err := Init(path, cfg, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := Init(path, cfg, nil); err == nil {
+				t.Fatal("expected second init to fail")
+			}
+
+			r, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Config().Datastore.Blockstore; got != kind {
+				t.Fatalf("config blockstore = %q, want %q", got, kind)
+			}
+			ctx := context.Background()
+			blk := blockformat.NewBlock([]byte("repo block"))
+			if err := r.Blockstore().Put(ctx, blk); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// blocks survive reopening
+			r, err = Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := r.Blockstore().Get(ctx, blk.Cid()); err != nil {
+				t.Fatal(err)
+			}
+			if _, isFlatfs := r.Blockstore().(*blockstore.FlatfsBlockstore); isFlatfs != (kind == "flatfs") {
+				t.Fatalf("unexpected blockstore type %T", r.Blockstore())
+			}
+		})
+	}
+}
+
+func TestDefaultPathFromEnv(t *testing.T) {
+	t.Setenv(EnvPath, "/tmp/custom-repo")
+	got, err := DefaultPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/tmp/custom-repo" {
+		t.Fatalf("DefaultPath() = %q", got)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		path := filepath.Join(t.TempDir(), "repo")
+		if err := Init(path, config.Default(), []byte(passphrase)); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		if passphrase != "" {
+			if _, err := r.Identity(nil); !errors.Is(err, ErrPassphraseRequired) {
+				t.Fatalf("expected ErrPassphraseRequired, got %v", err)
+			}
+			if _, err := r.Identity([]byte("wrong")); err == nil {
+				t.Fatal("expected error for wrong passphrase")
+			}
+		}
+		sk, err := r.Identity([]byte(passphrase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := peer.IDFromPrivateKey(sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.String() != r.Config().Identity.PeerID {
+			t.Fatalf("identity %s does not match config PeerID %s", id, r.Config().Identity.PeerID)
+		}
+	}
+}
+
+func TestInitWithIdentity(t *testing.T) {
+	sk, err := GenerateKey(KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "repo")
+	cfg := config.Default()
+	if err := InitWithIdentity(path, cfg, sk, []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadIdentity(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(sk) {
+		t.Fatal("repo identity differs from the given key")
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Identity.PeerID != id.String() {
+		t.Fatalf("config PeerID %s, want %s", cfg.Identity.PeerID, id)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo")
+	if err := Init(path, config.Default(), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(path)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if want := fmt.Sprintf("repo in use by PID %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Fatalf("error %q does not mention %q", err, want)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lock left by a process that no longer exists is taken over
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("cannot start a process to obtain a dead PID:", err)
+	}
+	stale := fmt.Sprintf("%d\n", cmd.Process.Pid)
+	if err := os.WriteFile(filepath.Join(path, LockFile), []byte(stale), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err = Open(path)
+	if err != nil {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(path, LockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), fmt.Sprintf("%d\n", os.Getpid()); got != want {
+		t.Fatalf("lock file holds %q, want %q", got, want)
+	}
+	r.Close()
+	r, err = Open(path)
+	if err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+	r.Close()
+}
+
+func TestLockConcurrentOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo")
+	if err := Init(path, config.Default(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// start from a stale lock so every opener races to take it over
+	if err := os.WriteFile(filepath.Join(path, LockFile), []byte("999999999\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	const openers = 8
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		opened []*Repo
+	)
+	start := make(chan struct{})
+	for i := 0; i < openers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			r, err := Open(path)
+			if err != nil {
+				if !errors.Is(err, ErrLocked) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			mu.Lock()
+			opened = append(opened, r)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, r := range opened {
+		r.Close()
+	}
+	if len(opened) != 1 {
+		t.Fatalf("%d openers hold the repo, want 1", len(opened))
+	}
+}
+
+func TestMigrateSharedMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo")
+	if err := Init(path, config.Default(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// recreate the version 1 layout: no version file, JSON index in metadata
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	meta := []byte(`{"a.txt":"cid-a","b.txt":"cid-b"}`)
+	if err := r.Datastore().Put(ctx, "metadata", []byte("shared_meta"), meta); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if err := os.Remove(filepath.Join(path, VersionFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Migrate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.From != 1 || res.To != CurrentVersion {
+		t.Fatalf("migrated %d -> %d, want 1 -> %d", res.From, res.To, CurrentVersion)
+	}
+	if _, err := os.Stat(res.Backup); err != nil {
+		t.Fatalf("backup missing: %v", err)
+	}
+	if v, err := Version(path); err != nil || v != CurrentVersion {
+		t.Fatalf("Version() = %d, %v", v, err)
+	}
+
+	r, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	shared := make(map[string]string)
+	err = r.Datastore().ForEach(ctx, SharedBucket, func(key, value []byte) error {
+		shared[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 2 || shared["a.txt"] != "cid-a" || shared["b.txt"] != "cid-b" {
+		t.Fatalf("shared bucket = %v", shared)
+	}
+	if _, err := r.Datastore().Get(ctx, "metadata", []byte("shared_meta")); !errors.Is(err, datastore.ErrNotFound) {
+		t.Fatalf("shared_meta not removed: %v", err)
+	}
+
+	// a repo from a newer build is refused
+	if err := writeVersion(path, CurrentVersion+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrate(path); err == nil {
+		t.Fatal("expected error for newer repo version")
+	}
+}
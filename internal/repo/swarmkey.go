@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// SwarmKeyFile holds the pre-shared key of a private swarm. Nodes with a
+// swarm key only talk to peers that have the same key.
+const SwarmKeyFile = "swarm.key"
+
+// SwarmKey returns the pre-shared key from the repo's swarm.key file, or nil
+// if the repo does not belong to a private swarm.
+func (r *Repo) SwarmKey() (pnet.PSK, error) {
+	f, err := os.Open(filepath.Join(r.path, SwarmKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", SwarmKeyFile, err)
+	}
+	return psk, nil
+}
@@ -0,0 +1,184 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ContentRouting announces and finds the providers of content.
+type ContentRouting interface {
+	// Provide announces that this node can serve c.
+	Provide(ctx context.Context, c cid.Cid, announce bool) error
+	// FindProvidersAsync streams up to limit providers of c, or all of
+	// them if limit is 0. The channel is closed when the search ends or
+	// ctx is cancelled.
+	FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo
+}
+
+// ErrNotSupported is returned by routers that cannot perform an operation,
+// such as StaticRouter.Provide.
+var ErrNotSupported = errors.New("routing: operation not supported")
+
+// Routing types accepted by Routing.Type in the config.
+const (
+	TypeDHT       = "dht"       // the DHT alongside static and delegated routers
+	TypeDelegated = "delegated" // static and delegated routers only, no DHT
+)
+
+// NoValueStore stores no records: every put and get fails with
+// ErrNotSupported. It stands in for the DHT on nodes that do not run one,
+// so names are only published to and resolved from the local cache.
+type NoValueStore struct{}
+
+// PutValue returns ErrNotSupported.
+func (NoValueStore) PutValue(ctx context.Context, key string, value []byte) error {
+	return ErrNotSupported
+}
+
+// GetValue returns ErrNotSupported.
+func (NoValueStore) GetValue(ctx context.Context, key string) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// FindProviders collects up to limit providers of c from r, waiting for
+// the whole search to finish. Prefer FindProvidersAsync to act on
+// providers as they are found.
+func FindProviders(ctx context.Context, r ContentRouting, c cid.Cid, limit int) []peer.AddrInfo {
+	var infos []peer.AddrInfo
+	for pi := range r.FindProvidersAsync(ctx, c, limit) {
+		infos = append(infos, pi)
+	}
+	return infos
+}
+
+// StaticRouter reports a fixed list of peers as providers of every CID and
+// cannot announce content. It suits small deployments where the peers
+// holding the content are known in advance.
+type StaticRouter struct {
+	peers []peer.AddrInfo
+}
+
+// NewStaticRouter returns a router that offers peers for every CID.
+func NewStaticRouter(peers []peer.AddrInfo) *StaticRouter {
+	return &StaticRouter{peers: peers}
+}
+
+// Provide returns ErrNotSupported: static providers are not told about
+// content.
+func (s *StaticRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return ErrNotSupported
+}
+
+// FindProvidersAsync streams the static peers.
+func (s *StaticRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		for i, pi := range s.peers {
+			if limit > 0 && i == limit {
+				return
+			}
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// ParallelRouter composes several routers, querying all of them at once.
+type ParallelRouter struct {
+	routers []ContentRouting
+}
+
+// NewParallelRouter returns a router over routers.
+func NewParallelRouter(routers ...ContentRouting) *ParallelRouter {
+	return &ParallelRouter{routers: routers}
+}
+
+// Provide announces c through every router. It succeeds if any router does
+// and otherwise returns the failures of the routers that support Provide.
+func (p *ParallelRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	errs := make([]error, len(p.routers))
+	var wg sync.WaitGroup
+	for i, r := range p.routers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = r.Provide(ctx, c, announce)
+		}()
+	}
+	wg.Wait()
+	var failed []error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			return nil
+		case !errors.Is(err, ErrNotSupported):
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return ErrNotSupported
+	}
+	return errors.Join(failed...)
+}
+
+// FindProvidersAsync queries every router and merges their results,
+// reporting each peer once. Addresses of a peer found by several routers
+// are not merged; the first result wins.
+func (p *ParallelRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	merged := make(chan peer.AddrInfo)
+	var wg sync.WaitGroup
+	for _, r := range p.routers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pi := range r.FindProvidersAsync(ctx, c, limit) {
+				select {
+				case merged <- pi:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		defer func() {
+			cancel()
+			// drain so the router goroutines can exit
+			for range merged {
+			}
+		}()
+		seen := make(map[peer.ID]bool)
+		for pi := range merged {
+			if seen[pi.ID] {
+				continue
+			}
+			seen[pi.ID] = true
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return
+			}
+			if limit > 0 && len(seen) == limit {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+// errRouter fails every Provide.
+type errRouter struct{ StaticRouter }
+
+func (errRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return errors.New("no peers")
+}
+
+// fakeProvidingRouter accepts every Provide.
+type fakeProvidingRouter struct{ StaticRouter }
+
+func (*fakeProvidingRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return nil
+}
+
+func testPeers(t *testing.T, n int) []peer.AddrInfo {
+	t.Helper()
+	var peers []peer.AddrInfo
+	for range n {
+		id, err := test.RandPeerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer.AddrInfo{ID: id})
+	}
+	return peers
+}
+
+func TestParallelRouterFindProviders(t *testing.T) {
+	ctx := context.Background()
+	c := blockformat.NewBlock([]byte("content")).Cid()
+	p := testPeers(t, 3)
+	r := NewParallelRouter(NewStaticRouter(p[:2]), NewStaticRouter(p[1:]))
+
+	got := FindProviders(ctx, r, c, 0)
+	if len(got) != 3 {
+		t.Fatalf("found %d providers, want 3 distinct: %v", len(got), got)
+	}
+	seen := make(map[peer.ID]bool)
+	for _, pi := range got {
+		if seen[pi.ID] {
+			t.Fatalf("provider %s reported twice", pi.ID)
+		}
+		seen[pi.ID] = true
+	}
+	if got := FindProviders(ctx, r, c, 2); len(got) != 2 {
+		t.Fatalf("found %d providers with limit 2", len(got))
+	}
+}
+
+func TestParallelRouterProvide(t *testing.T) {
+	ctx := context.Background()
+	c := blockformat.NewBlock([]byte("content")).Cid()
+	static := NewStaticRouter(nil)
+	failing := &errRouter{}
+
+	if err := NewParallelRouter(static).Provide(ctx, c, true); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("static only: got %v, want ErrNotSupported", err)
+	}
+	if err := NewParallelRouter(static, failing).Provide(ctx, c, true); err == nil || errors.Is(err, ErrNotSupported) {
+		t.Fatalf("a failing router must not be hidden by a static one: %v", err)
+	}
+	if err := NewParallelRouter(failing, &fakeProvidingRouter{}).Provide(ctx, c, true); err != nil {
+		t.Fatalf("one router succeeded: %v", err)
+	}
+}
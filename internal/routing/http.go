@@ -0,0 +1,237 @@
+package routing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ProvidersPath is the path prefix of the delegated routing (Routing V1)
+// provider lookup endpoint; the CID follows it.
+const ProvidersPath = "/routing/v1/providers/"
+
+// Content types of the Routing V1 API. Streaming responses carry one record
+// per line.
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// httpLookupTimeout bounds a provider lookup served over HTTP.
+const httpLookupTimeout = 30 * time.Second
+
+// httpProviderLimit caps the number of providers returned per lookup.
+const httpProviderLimit = 20
+
+// providerRecord is a Routing V1 peer record.
+type providerRecord struct {
+	Schema string
+	ID     string
+	Addrs  []string `json:",omitempty"`
+}
+
+// providersResponse is the non-streaming Routing V1 provider response.
+type providersResponse struct {
+	Providers []providerRecord
+}
+
+// HTTPRouter finds providers through a delegated routing server speaking
+// the Routing V1 HTTP API, for nodes that do not run a DHT themselves.
+type HTTPRouter struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPRouter returns a router querying the server at baseURL, e.g.
+// "http://127.0.0.1:5001". A nil client means http.DefaultClient.
+func NewHTTPRouter(baseURL string, client *http.Client) *HTTPRouter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPRouter{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Provide returns ErrNotSupported: the Routing V1 API has no way to
+// announce content.
+func (r *HTTPRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return ErrNotSupported
+}
+
+// FindProvidersAsync looks up the providers of c on the server. Lookup
+// errors are logged and end the stream.
+func (r *HTTPRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		sent := 0
+		err := r.FindProvidersFunc(ctx, c, func(pi peer.AddrInfo) bool {
+			select {
+			case out <- pi:
+			case <-ctx.Done():
+				return false
+			}
+			sent++
+			return limit == 0 || sent < limit
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("delegated routing %s: %v", r.baseURL, err)
+		}
+	}()
+	return out
+}
+
+// FindProvidersFunc calls fn for every provider returned by the server, as
+// it arrives, until fn returns false. Unlike FindProvidersAsync it reports
+// lookup errors.
+func (r *HTTPRouter) FindProvidersFunc(ctx context.Context, c cid.Cid, fn func(peer.AddrInfo) bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+ProvidersPath+c.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaTypeNDJSON+", "+mediaTypeJSON)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == mediaTypeNDJSON {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			var rec providerRecord
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				return err
+			}
+			if pi, ok := rec.addrInfo(); ok && !fn(pi) {
+				return nil
+			}
+		}
+		return sc.Err()
+	}
+	var body providersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	for _, rec := range body.Providers {
+		if pi, ok := rec.addrInfo(); ok && !fn(pi) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// addrInfo converts a peer record, skipping records of other schemas and
+// addresses that do not parse.
+func (rec providerRecord) addrInfo() (peer.AddrInfo, bool) {
+	if rec.Schema != "peer" {
+		return peer.AddrInfo{}, false
+	}
+	id, err := peer.Decode(rec.ID)
+	if err != nil {
+		return peer.AddrInfo{}, false
+	}
+	pi := peer.AddrInfo{ID: id}
+	for _, s := range rec.Addrs {
+		if a, err := ma.NewMultiaddr(s); err == nil {
+			pi.Addrs = append(pi.Addrs, a)
+		}
+	}
+	return pi, true
+}
+
+// NewHTTPHandler serves Routing V1 provider lookups at ProvidersPath from r,
+// so thin clients can use this node as their delegated router. Providers
+// found without addresses, including h itself, get the addresses h knows.
+func NewHTTPHandler(r ContentRouting, h host.Host) http.Handler {
+	return &httpHandler{router: r, host: h}
+}
+
+type httpHandler struct {
+	router ContentRouting
+	host   host.Host
+}
+
+func (s *httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c, err := cid.Parse(strings.TrimPrefix(req.URL.Path, ProvidersPath))
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), httpLookupTimeout)
+	defer cancel()
+	providers := s.router.FindProvidersAsync(ctx, c, httpProviderLimit)
+
+	if !strings.Contains(req.Header.Get("Accept"), mediaTypeNDJSON) {
+		var body providersResponse
+		for pi := range providers {
+			body.Providers = append(body.Providers, s.record(pi))
+		}
+		if len(body.Providers) == 0 {
+			http.Error(w, "no providers found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeJSON)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	found := false
+	for pi := range providers {
+		if !found {
+			w.Header().Set("Content-Type", mediaTypeNDJSON)
+			found = true
+		}
+		if err := enc.Encode(s.record(pi)); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if !found {
+		http.Error(w, "no providers found", http.StatusNotFound)
+	}
+}
+
+// record converts a provider found by the router to a peer record.
+func (s *httpHandler) record(pi peer.AddrInfo) providerRecord {
+	addrs := pi.Addrs
+	if len(addrs) == 0 {
+		if pi.ID == s.host.ID() {
+			addrs = s.host.Addrs()
+		} else {
+			addrs = s.host.Peerstore().Addrs(pi.ID)
+		}
+	}
+	rec := providerRecord{Schema: "peer", ID: pi.ID.String()}
+	for _, a := range addrs {
+		rec.Addrs = append(rec.Addrs, a.String())
+	}
+	return rec
+}
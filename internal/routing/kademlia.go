@@ -2,21 +2,78 @@ package routing
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
-// KademliaDHT wraps a libp2p Kademlia DHT instance.
+// PrivateProtocolPrefix is the DHT protocol prefix used by private swarms so
+// they never join the public IPFS DHT.
+const PrivateProtocolPrefix protocol.ID = "/p2pfs/private"
+
+// DHTBucket holds the DHT state, such as provider records, when it is
+// persisted in a repo datastore.
+const DHTBucket = "dht"
+
+// DHT modes accepted by Options.Mode.
+const (
+	ModeAuto   = "auto"   // server when publicly reachable, client otherwise
+	ModeClient = "client" // only query the DHT
+	ModeServer = "server" // also answer queries and store records
+)
+
+// Options configures NewKademliaDHT.
+type Options struct {
+	// Private selects PrivateProtocolPrefix instead of the public /ipfs
+	// prefix. Set it for hosts created with a swarm key.
+	Private bool
+	// Mode is one of ModeAuto, ModeClient or ModeServer; empty means
+	// ModeAuto.
+	Mode string
+	// BucketSize is the number of peers per routing table bucket; 0 keeps
+	// the libp2p default of 20.
+	BucketSize int
+	// Datastore, if set, persists provider records and other DHT state.
+	// Otherwise they are kept in memory and lost on restart.
+	Datastore ds.Batching
+}
+
+// KademliaDHT wraps a libp2p Kademlia DHT instance. It implements
+// ContentRouting.
 type KademliaDHT struct {
 	dht *kaddht.IpfsDHT
 }
 
 // NewKademliaDHT constructs and bootstraps a Kademlia DHT.
-func NewKademliaDHT(ctx context.Context, h host.Host) (*KademliaDHT, error) {
-	d, err := kaddht.New(ctx, h)
+func NewKademliaDHT(ctx context.Context, h host.Host, opts Options) (*KademliaDHT, error) {
+	var dhtOpts []kaddht.Option
+	if opts.Private {
+		dhtOpts = append(dhtOpts, kaddht.ProtocolPrefix(PrivateProtocolPrefix))
+	}
+	switch opts.Mode {
+	case ModeAuto, "":
+		dhtOpts = append(dhtOpts, kaddht.Mode(kaddht.ModeAuto))
+	case ModeClient:
+		dhtOpts = append(dhtOpts, kaddht.Mode(kaddht.ModeClient))
+	case ModeServer:
+		dhtOpts = append(dhtOpts, kaddht.Mode(kaddht.ModeServer))
+	default:
+		return nil, fmt.Errorf("unknown dht mode %q (want %s, %s or %s)", opts.Mode, ModeAuto, ModeClient, ModeServer)
+	}
+	if opts.BucketSize > 0 {
+		dhtOpts = append(dhtOpts, kaddht.BucketSize(opts.BucketSize))
+	}
+	if opts.Datastore != nil {
+		dhtOpts = append(dhtOpts, kaddht.Datastore(opts.Datastore))
+	}
+	d, err := kaddht.New(ctx, h, dhtOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -29,17 +86,111 @@ func (k *KademliaDHT) Provide(ctx context.Context, c cid.Cid, announce bool) err
 	return k.dht.Provide(ctx, c, announce)
 }
 
-// FindProviders finds up to `max` providers for the CID.
-func (k *KademliaDHT) FindProviders(ctx context.Context, c cid.Cid, max int) ([]peer.AddrInfo, error) {
-	ch := k.dht.FindProvidersAsync(ctx, c, max)
-	var infos []peer.AddrInfo
-	for pi := range ch {
-		infos = append(infos, pi)
-	}
-	return infos, nil
+// FindProvidersAsync streams up to limit providers of c found in the DHT.
+func (k *KademliaDHT) FindProvidersAsync(ctx context.Context, c cid.Cid, limit int) <-chan peer.AddrInfo {
+	return k.dht.FindProvidersAsync(ctx, c, limit)
+}
+
+// PutValue stores a record under key locally and on the peers closest to
+// it. Records are validated by the namespace of the key; IPNS records
+// under /ipns/ must be signed by the key the name is derived from.
+func (k *KademliaDHT) PutValue(ctx context.Context, key string, value []byte) error {
+	return k.dht.PutValue(ctx, key, value)
+}
+
+// GetValue returns the best valid record stored under key, locally or on
+// the network.
+func (k *KademliaDHT) GetValue(ctx context.Context, key string) ([]byte, error) {
+	return k.dht.GetValue(ctx, key)
 }
 
 // Bootstrap triggers the DHT bootstrap process.
 func (k *KademliaDHT) Bootstrap(ctx context.Context) error {
 	return k.dht.Bootstrap(ctx)
 }
+
+// RefreshRoutingTable queries the network to refill the routing table and
+// waits for the refresh to finish.
+func (k *KademliaDHT) RefreshRoutingTable(ctx context.Context) error {
+	select {
+	case err := <-k.dht.RefreshRoutingTable():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddPeer adds a peer found outside the DHT, e.g. on the local network, to
+// the routing table. It reports whether the peer was newly added.
+func (k *KademliaDHT) AddPeer(p peer.ID) (bool, error) {
+	return k.dht.RoutingTable().TryAddPeer(p, true, true)
+}
+
+// RoutingTableSize returns the number of peers in the routing table.
+func (k *KademliaDHT) RoutingTableSize() int {
+	return k.dht.RoutingTable().Size()
+}
+
+// Mode reports whether the DHT currently runs as a client or a server.
+func (k *KademliaDHT) Mode() string {
+	if k.dht.Mode() == kaddht.ModeServer {
+		return ModeServer
+	}
+	return ModeClient
+}
+
+// Bucket groups the routing table peers sharing CommonPrefixLen leading
+// bits with this node's DHT key.
+type Bucket struct {
+	CommonPrefixLen int
+	Peers           []TablePeer
+}
+
+// TablePeer is a peer in the routing table.
+type TablePeer struct {
+	ID peer.ID
+	// AddedAt is when the peer entered the table.
+	AddedAt time.Time
+	// LastUsefulAt is when the peer last answered a query with useful
+	// results; zero if never.
+	LastUsefulAt time.Time
+	// LastSuccessfulQueryAt is when a query to the peer last succeeded.
+	LastSuccessfulQueryAt time.Time
+}
+
+// RoutingTable returns the non-empty buckets of the routing table, ordered
+// by common prefix length.
+func (k *KademliaDHT) RoutingTable() []Bucket {
+	rt := k.dht.RoutingTable()
+	self := kb.ConvertPeerID(k.dht.PeerID())
+	byCpl := make(map[int][]TablePeer)
+	maxCpl := 0
+	for _, pi := range rt.GetPeerInfos() {
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(pi.Id))
+		byCpl[cpl] = append(byCpl[cpl], TablePeer{
+			ID:                    pi.Id,
+			AddedAt:               pi.AddedAt,
+			LastUsefulAt:          pi.LastUsefulAt,
+			LastSuccessfulQueryAt: pi.LastSuccessfulOutboundQueryAt,
+		})
+		maxCpl = max(maxCpl, cpl)
+	}
+	var buckets []Bucket
+	for cpl := 0; cpl <= maxCpl; cpl++ {
+		if peers := byCpl[cpl]; len(peers) > 0 {
+			buckets = append(buckets, Bucket{CommonPrefixLen: cpl, Peers: peers})
+		}
+	}
+	return buckets
+}
+
+// GetClosestPeers queries the network for the peers closest to p in the
+// DHT keyspace.
+func (k *KademliaDHT) GetClosestPeers(ctx context.Context, p peer.ID) ([]peer.ID, error) {
+	return k.dht.GetClosestPeers(ctx, string(p))
+}
+
+// Close shuts down the DHT.
+func (k *KademliaDHT) Close() error {
+	return k.dht.Close()
+}
@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+	"p2pfs/internal/datastore"
+)
+
+// NoProvideBucket holds the roots of DAGs added with announcing turned off.
+// Their blocks are left out of every reprovide, whatever the strategy.
+const NoProvideBucket = "no-provide"
+
+// SetNoProvide records whether the DAG under root is kept unannounced.
+func SetNoProvide(ctx context.Context, ds datastore.Datastore, root cid.Cid, noProvide bool) error {
+	if !noProvide {
+		err := ds.Delete(ctx, NoProvideBucket, root.Bytes())
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return ds.Put(ctx, NoProvideBucket, root.Bytes(), nil)
+}
+
+// ExcludeNoProvide returns a key source listing the keys of keys except the
+// blocks of the DAGs recorded in NoProvideBucket, matched by multihash. A
+// block shared with other content is left out too: the opt-out wins.
+func ExcludeNoProvide(keys KeyChanFunc, bs blockstore.Blockstore, ds datastore.Datastore) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		var roots []cid.Cid
+		err := ds.ForEach(ctx, NoProvideBucket, func(key, _ []byte) error {
+			root, err := cid.Cast(key)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, root)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		excluded := make(map[string]bool)
+		for _, root := range roots {
+			err := dag.Walk(ctx, bs, root, func(c cid.Cid) error {
+				excluded[string(c.Hash())] = true
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		in, err := keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(excluded) == 0 {
+			return in, nil
+		}
+		return sendKeys(ctx, func(send func(cid.Cid) error) error {
+			for c := range in {
+				if excluded[string(c.Hash())] {
+					continue
+				}
+				if err := send(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), nil
+	}
+}
@@ -0,0 +1,237 @@
+package routing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+	"p2pfs/internal/datastore"
+)
+
+// ProvideQueueBucket holds the CIDs waiting to be announced, with the number
+// of failed attempts and the time of the next one.
+const ProvideQueueBucket = "provide-queue"
+
+// QueueOptions configures ProvideQueue.Run.
+type QueueOptions struct {
+	// Workers is the number of concurrent provides.
+	Workers int
+	// MaxAttempts is the number of failed provides after which a CID is
+	// dropped from the queue and left to the reprovider.
+	MaxAttempts int
+	// RetryDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+}
+
+// DefaultQueueOptions returns the options the daemon drains its queue with.
+func DefaultQueueOptions() QueueOptions {
+	return QueueOptions{
+		Workers:       8,
+		MaxAttempts:   10,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: time.Hour,
+	}
+}
+
+// ProvideQueue is a persistent queue of CIDs to announce. Commands that
+// import content enqueue it; a running node drains the queue in the
+// background, so announcements survive restarts and are retried on failure.
+type ProvideQueue struct {
+	ds     datastore.Datastore
+	notify chan struct{}
+}
+
+// NewProvideQueue returns the provide queue kept in ds.
+func NewProvideQueue(ds datastore.Datastore) *ProvideQueue {
+	return &ProvideQueue{ds: ds, notify: make(chan struct{}, 1)}
+}
+
+// ProvideKeys returns the CIDs of the DAG under root that strategy
+// announces: only root for StrategyRoots, every local block of the DAG
+// otherwise.
+func ProvideKeys(ctx context.Context, strategy string, bs blockstore.Blockstore, root cid.Cid) ([]cid.Cid, error) {
+	switch strategy {
+	case StrategyRoots:
+		return []cid.Cid{root}, nil
+	case StrategyAll, StrategyPinned, "":
+	default:
+		return nil, fmt.Errorf("unknown reprovider strategy %q", strategy)
+	}
+	var keys []cid.Cid
+	err := dag.Walk(ctx, bs, root, func(c cid.Cid) error {
+		keys = append(keys, c)
+		return nil
+	})
+	return keys, err
+}
+
+// Enqueue adds cids to the queue to be announced as soon as possible.
+func (q *ProvideQueue) Enqueue(ctx context.Context, cids ...cid.Cid) error {
+	for _, c := range cids {
+		if err := q.ds.Put(ctx, ProvideQueueBucket, c.Bytes(), encodeQueueEntry(0, time.Time{})); err != nil {
+			return err
+		}
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of queued CIDs.
+func (q *ProvideQueue) Len(ctx context.Context) (int, error) {
+	n := 0
+	err := q.ds.ForEach(ctx, ProvideQueueBucket, func(_, _ []byte) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Run announces queued CIDs through provider until ctx is cancelled. A CID
+// is removed from the queue once announced and its announcement recorded in
+// ProvidedBucket; failed ones are retried with exponential backoff.
+func (q *ProvideQueue) Run(ctx context.Context, provider Provider, opts QueueOptions) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-q.notify:
+		case <-ctx.Done():
+			return
+		}
+		next, err := q.drain(ctx, provider, opts)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("provide queue: %v", err)
+		}
+		timer.Stop()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// queueEntry is a CID due to be announced.
+type queueEntry struct {
+	c        cid.Cid
+	attempts int
+}
+
+// drain announces every due CID and returns when the earliest remaining one
+// is due, or the zero time if the queue is empty.
+func (q *ProvideQueue) drain(ctx context.Context, provider Provider, opts QueueOptions) (time.Time, error) {
+	now := time.Now()
+	var due []queueEntry
+	var next time.Time
+	err := q.ds.ForEach(ctx, ProvideQueueBucket, func(key, value []byte) error {
+		c, err := cid.Cast(key)
+		if err != nil {
+			return err
+		}
+		attempts, at := decodeQueueEntry(value)
+		if at.After(now) {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+			return nil
+		}
+		due = append(due, queueEntry{c: c, attempts: attempts})
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	sem := make(chan struct{}, max(opts.Workers, 1))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, e := range due {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return time.Time{}, ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			retryAt, err := q.provide(ctx, provider, opts, e)
+			if err != nil {
+				log.Printf("provide queue: %s: %v", e.c, err)
+			}
+			if !retryAt.IsZero() {
+				mu.Lock()
+				if next.IsZero() || retryAt.Before(next) {
+					next = retryAt
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return next, nil
+}
+
+// provide announces a single queued CID and updates the queue. It returns
+// when the CID should be retried, or the zero time if it left the queue.
+func (q *ProvideQueue) provide(ctx context.Context, provider Provider, opts QueueOptions, e queueEntry) (time.Time, error) {
+	perr := provider.Provide(ctx, e.c, true)
+	if perr == nil {
+		if err := recordProvided(ctx, q.ds, e.c, time.Now()); err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, q.ds.Delete(ctx, ProvideQueueBucket, e.c.Bytes())
+	}
+	if ctx.Err() != nil {
+		return time.Time{}, nil
+	}
+	attempts := e.attempts + 1
+	if attempts >= opts.MaxAttempts {
+		if err := q.ds.Delete(ctx, ProvideQueueBucket, e.c.Bytes()); err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, fmt.Errorf("giving up after %d attempts: %w", attempts, perr)
+	}
+	delay := opts.RetryDelay << (attempts - 1)
+	if delay <= 0 || delay > opts.MaxRetryDelay {
+		delay = opts.MaxRetryDelay
+	}
+	retryAt := time.Now().Add(delay)
+	if err := q.ds.Put(ctx, ProvideQueueBucket, e.c.Bytes(), encodeQueueEntry(attempts, retryAt)); err != nil {
+		return time.Time{}, err
+	}
+	return retryAt, nil
+}
+
+// encodeQueueEntry packs the attempt count and the next attempt time.
+func encodeQueueEntry(attempts int, at time.Time) []byte {
+	var ns int64
+	if !at.IsZero() {
+		ns = at.UnixNano()
+	}
+	v := binary.BigEndian.AppendUint32(nil, uint32(attempts))
+	return binary.BigEndian.AppendUint64(v, uint64(ns))
+}
+
+func decodeQueueEntry(v []byte) (attempts int, at time.Time) {
+	if len(v) != 12 {
+		return 0, time.Time{}
+	}
+	attempts = int(binary.BigEndian.Uint32(v))
+	if ns := int64(binary.BigEndian.Uint64(v[4:])); ns != 0 {
+		at = time.Unix(0, ns)
+	}
+	return attempts, at
+}
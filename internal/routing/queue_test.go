@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+
+	"p2pfs/internal/datastore"
+)
+
+func TestProvideQueueRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := datastore.NewMapDatastore()
+	q := NewProvideQueue(ds)
+	a := blockformat.NewBlock([]byte("a")).Cid()
+	b := blockformat.NewBlock([]byte("b")).Cid()
+	if err := q.Enqueue(ctx, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.Len(ctx); n != 2 {
+		t.Fatalf("queue length = %d, want 2", n)
+	}
+
+	p := &fakeProvider{fail: map[cid.Cid]bool{b: true}}
+	opts := QueueOptions{Workers: 2, MaxAttempts: 3, RetryDelay: 10 * time.Millisecond, MaxRetryDelay: 20 * time.Millisecond}
+	next, err := q.drain(ctx, p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.IsZero() {
+		t.Fatal("failed provide was not scheduled for a retry")
+	}
+	if n, _ := q.Len(ctx); n != 1 {
+		t.Fatalf("queue length after first drain = %d, want 1", n)
+	}
+	r := NewReprovider(p, ds, nil, ReproviderOptions{})
+	if last, _ := r.LastProvided(ctx, a); last.IsZero() {
+		t.Fatal("announced CID not recorded as provided")
+	}
+
+	// b keeps failing and is dropped after MaxAttempts
+	go q.Run(ctx, p, opts)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if n, _ := q.Len(ctx); n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("queue not drained")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := p.take(); len(got) != 1 || got[0] != a.String() {
+		t.Fatalf("provided %v, want [%s]", got, a)
+	}
+}
+
+func TestProvideKeys(t *testing.T) {
+	ctx := context.Background()
+	root := blockformat.NewBlock([]byte("root")).Cid()
+	keys, err := ProvideKeys(ctx, StrategyRoots, nil, root)
+	if err != nil || len(keys) != 1 || keys[0] != root {
+		t.Fatalf("roots strategy: %v, %v", keys, err)
+	}
+	if _, err := ProvideKeys(ctx, "some", nil, root); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
@@ -0,0 +1,264 @@
+package routing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/dag"
+	"p2pfs/internal/datastore"
+)
+
+// ProvidedBucket maps CIDs to the time they were last announced.
+const ProvidedBucket = "provided"
+
+// Reprovide strategies select the CIDs a Reprovider announces.
+const (
+	StrategyAll    = "all"    // every block in the blockstore
+	StrategyPinned = "pinned" // every block of the pinned DAGs
+	StrategyRoots  = "roots"  // only the pinned roots
+)
+
+// DefaultBatchSize is the number of provides a Reprovider runs concurrently.
+const DefaultBatchSize = 16
+
+// Provider announces CIDs to a content routing system.
+type Provider interface {
+	Provide(ctx context.Context, c cid.Cid, announce bool) error
+}
+
+// KeyChanFunc returns the CIDs to reprovide.
+type KeyChanFunc func(ctx context.Context) (<-chan cid.Cid, error)
+
+// NewStrategyKeys returns the key source for strategy. pins lists the pinned
+// roots and is only used by the pinned and roots strategies.
+func NewStrategyKeys(strategy string, bs blockstore.Blockstore, pins func(ctx context.Context) ([]cid.Cid, error)) (KeyChanFunc, error) {
+	switch strategy {
+	case StrategyAll, "":
+		return bs.AllKeysChan, nil
+	case StrategyRoots:
+		return func(ctx context.Context) (<-chan cid.Cid, error) {
+			roots, err := pins(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return sendKeys(ctx, func(send func(cid.Cid) error) error {
+				for _, c := range roots {
+					if err := send(c); err != nil {
+						return err
+					}
+				}
+				return nil
+			}), nil
+		}, nil
+	case StrategyPinned:
+		return func(ctx context.Context) (<-chan cid.Cid, error) {
+			roots, err := pins(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return sendKeys(ctx, func(send func(cid.Cid) error) error {
+				seen := cid.NewSet()
+				for _, root := range roots {
+					err := dag.Walk(ctx, bs, root, func(c cid.Cid) error {
+						if !seen.Visit(c) {
+							return nil
+						}
+						return send(c)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown reprovider strategy %q (want %s, %s or %s)", strategy, StrategyAll, StrategyPinned, StrategyRoots)
+	}
+}
+
+// sendKeys runs gen in a goroutine, streaming the keys it sends on a channel
+// that is closed when gen returns or ctx is cancelled.
+func sendKeys(ctx context.Context, gen func(send func(cid.Cid) error) error) <-chan cid.Cid {
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		err := gen(func(c cid.Cid) error {
+			select {
+			case out <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("reprovider: listing keys: %v", err)
+		}
+	}()
+	return out
+}
+
+// ReproviderOptions configures NewReprovider.
+type ReproviderOptions struct {
+	// Interval between runs. CIDs announced less than half an interval ago,
+	// e.g. before a restart, are skipped.
+	Interval time.Duration
+	// InitialDelay is the wait before the first run, giving the node time to
+	// connect to the network.
+	InitialDelay time.Duration
+	// BatchSize is the number of concurrent provides; 0 means
+	// DefaultBatchSize.
+	BatchSize int
+}
+
+// ReprovideResult summarizes a reprovide run.
+type ReprovideResult struct {
+	Provided int
+	Skipped  int
+	Failed   int
+}
+
+// Reprovider periodically re-announces CIDs so their provider records do
+// not expire from the DHT. The time each CID was last announced is kept in
+// ProvidedBucket.
+type Reprovider struct {
+	provider Provider
+	ds       datastore.Datastore
+	keys     KeyChanFunc
+	opts     ReproviderOptions
+}
+
+// NewReprovider returns a Reprovider announcing the CIDs from keys through
+// provider and recording announcements in ds.
+func NewReprovider(provider Provider, ds datastore.Datastore, keys KeyChanFunc, opts ReproviderOptions) *Reprovider {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	return &Reprovider{provider: provider, ds: ds, keys: keys, opts: opts}
+}
+
+// Run reprovides after InitialDelay and then every Interval until ctx is
+// cancelled. Failures are logged; they are retried on the next run.
+func (r *Reprovider) Run(ctx context.Context) {
+	timer := time.NewTimer(r.opts.InitialDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+		res, err := r.Reprovide(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("reprovider: provided %d, skipped %d, failed %d: %v", res.Provided, res.Skipped, res.Failed, err)
+		}
+		timer.Reset(r.opts.Interval)
+	}
+}
+
+// Reprovide announces every CID from the key source that was not announced
+// within the last half interval. If any provide fails the error reports how
+// many did and wraps the first failure; the CIDs that succeeded are recorded
+// either way.
+func (r *Reprovider) Reprovide(ctx context.Context) (ReprovideResult, error) {
+	var res ReprovideResult
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys, err := r.keys(ctx)
+	if err != nil {
+		return res, err
+	}
+	var firstErr error
+	batch := make([]cid.Cid, 0, r.opts.BatchSize)
+	flush := func() {
+		provided, failed := r.provideBatch(ctx, batch)
+		res.Provided += provided
+		res.Failed += len(failed)
+		if firstErr == nil && len(failed) > 0 {
+			firstErr = failed[0]
+		}
+		batch = batch[:0]
+	}
+	cutoff := time.Now().Add(-r.opts.Interval / 2)
+	for c := range keys {
+		last, err := r.LastProvided(ctx, c)
+		if err != nil {
+			return res, err
+		}
+		if last.After(cutoff) {
+			res.Skipped++
+			continue
+		}
+		batch = append(batch, c)
+		if len(batch) == r.opts.BatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if firstErr != nil {
+		return res, fmt.Errorf("%d of %d provides failed: %w", res.Failed, res.Provided+res.Failed, firstErr)
+	}
+	return res, nil
+}
+
+// provideBatch announces cids concurrently and records the successful ones.
+func (r *Reprovider) provideBatch(ctx context.Context, cids []cid.Cid) (provided int, failed []error) {
+	errs := make([]error, len(cids))
+	var wg sync.WaitGroup
+	for i, c := range cids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.provider.Provide(ctx, c, true); err != nil {
+				errs[i] = fmt.Errorf("provide %s: %w", c, err)
+			}
+		}()
+	}
+	wg.Wait()
+	now := time.Now()
+	for i, c := range cids {
+		if errs[i] == nil {
+			errs[i] = recordProvided(ctx, r.ds, c, now)
+		}
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			continue
+		}
+		provided++
+	}
+	return provided, failed
+}
+
+// LastProvided returns when c was last announced, or the zero time if never.
+func (r *Reprovider) LastProvided(ctx context.Context, c cid.Cid) (time.Time, error) {
+	v, err := r.ds.Get(ctx, ProvidedBucket, c.Bytes())
+	if errors.Is(err, datastore.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(v) != 8 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), nil
+}
+
+// recordProvided records in ds that c was announced at t.
+func recordProvided(ctx context.Context, ds datastore.Datastore, c cid.Cid, t time.Time) error {
+	v := binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+	return ds.Put(ctx, ProvidedBucket, c.Bytes(), v)
+}
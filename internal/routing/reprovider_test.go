@@ -0,0 +1,207 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	merkledag "github.com/ipfs/go-merkledag"
+
+	"p2pfs/internal/blockstore"
+	"p2pfs/internal/datastore"
+	"p2pfs/internal/pin"
+)
+
+// fakeProvider records provided CIDs and fails those listed in fail.
+type fakeProvider struct {
+	mu       sync.Mutex
+	provided []cid.Cid
+	fail     map[cid.Cid]bool
+}
+
+func (p *fakeProvider) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail[c] {
+		return errors.New("no peers")
+	}
+	p.provided = append(p.provided, c)
+	return nil
+}
+
+func (p *fakeProvider) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []string
+	for _, c := range p.provided {
+		out = append(out, c.String())
+	}
+	sort.Strings(out)
+	p.provided = nil
+	return out
+}
+
+func sortedStrings(cids ...cid.Cid) []string {
+	var out []string
+	for _, c := range cids {
+		out = append(out, c.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestReproviderStrategies(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMapBlockstore()
+	ds := datastore.NewMapDatastore()
+	pins := pin.NewStore(ds)
+
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	root := merkledag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	loose := blockformat.NewBlock([]byte("unpinned"))
+	for _, blk := range []blockformat.Block{leaf, root, loose} {
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pins.Add(ctx, root.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		strategy string
+		want     []string
+	}{
+		{StrategyAll, sortedStrings(leaf.Cid(), root.Cid(), loose.Cid())},
+		{StrategyPinned, sortedStrings(leaf.Cid(), root.Cid())},
+		{StrategyRoots, sortedStrings(root.Cid())},
+	} {
+		keys, err := NewStrategyKeys(tc.strategy, bs, pins.List)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &fakeProvider{}
+		// a fresh datastore so earlier runs are not skipped
+		r := NewReprovider(p, datastore.NewMapDatastore(), keys, ReproviderOptions{Interval: time.Hour, BatchSize: 2})
+		res, err := r.Reprovide(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.strategy, err)
+		}
+		got := p.take()
+		if len(got) != len(tc.want) || res.Provided != len(tc.want) {
+			t.Fatalf("%s: provided %v (%+v), want %v", tc.strategy, got, res, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: provided %v, want %v", tc.strategy, got, tc.want)
+			}
+		}
+	}
+	if _, err := NewStrategyKeys("everything", bs, pins.List); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
+
+func TestReproviderSkipsNoProvide(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMapBlockstore()
+	ds := datastore.NewMapDatastore()
+	pins := pin.NewStore(ds)
+
+	leaf := merkledag.NewRawNode([]byte("quiet leaf"))
+	quiet := merkledag.NodeWithData([]byte("quiet root"))
+	if err := quiet.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	loud := blockformat.NewBlock([]byte("loud"))
+	for _, blk := range []blockformat.Block{leaf, quiet, loud} {
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, root := range []cid.Cid{quiet.Cid(), loud.Cid()} {
+		if err := pins.Add(ctx, root); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetNoProvide(ctx, ds, quiet.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strategy := range []string{StrategyAll, StrategyPinned, StrategyRoots} {
+		keys, err := NewStrategyKeys(strategy, bs, pins.List)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &fakeProvider{}
+		r := NewReprovider(p, datastore.NewMapDatastore(), ExcludeNoProvide(keys, bs, ds), ReproviderOptions{Interval: time.Hour})
+		if _, err := r.Reprovide(ctx); err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		if got, want := p.take(), sortedStrings(loud.Cid()); len(got) != 1 || got[0] != want[0] {
+			t.Fatalf("%s: provided %v, want %v", strategy, got, want)
+		}
+	}
+
+	// clearing the mark announces the DAG again
+	if err := SetNoProvide(ctx, ds, quiet.Cid(), false); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewStrategyKeys(StrategyAll, bs, pins.List)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{}
+	r := NewReprovider(p, datastore.NewMapDatastore(), ExcludeNoProvide(keys, bs, ds), ReproviderOptions{Interval: time.Hour})
+	if res, err := r.Reprovide(ctx); err != nil || res.Provided != 3 {
+		t.Fatalf("after clearing the mark: %+v, %v", res, err)
+	}
+}
+
+func TestReproviderSkipsAndReportsFailures(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMapBlockstore()
+	ds := datastore.NewMapDatastore()
+	a := blockformat.NewBlock([]byte("a"))
+	b := blockformat.NewBlock([]byte("b"))
+	for _, blk := range []blockformat.Block{a, b} {
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := NewStrategyKeys(StrategyAll, bs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{fail: map[cid.Cid]bool{b.Cid(): true}}
+	r := NewReprovider(p, ds, keys, ReproviderOptions{Interval: time.Hour})
+
+	res, err := r.Reprovide(ctx)
+	if err == nil || res.Provided != 1 || res.Failed != 1 {
+		t.Fatalf("expected one failure, got %+v, %v", res, err)
+	}
+	if last, err := r.LastProvided(ctx, a.Cid()); err != nil || last.IsZero() {
+		t.Fatalf("last provided time of %s not recorded: %v, %v", a.Cid(), last, err)
+	}
+
+	// a was announced just now and is skipped; b is retried
+	p.fail = nil
+	res, err = r.Reprovide(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped != 1 || res.Provided != 1 {
+		t.Fatalf("second run: %+v", res)
+	}
+	if got := p.take(); len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("provided %v", got)
+	}
+}